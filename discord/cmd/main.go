@@ -10,6 +10,9 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/vcaldo/cerverox9/discord/pkg/handlers"
 	"github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/pipeline"
+	"github.com/vcaldo/cerverox9/discord/pkg/rewards"
+	"github.com/vcaldo/cerverox9/discord/pkg/tracker"
 )
 
 func main() {
@@ -29,9 +32,26 @@ func main() {
 	dg.Identify.Intents = discordgo.IntentGuilds |
 		discordgo.IntentsGuildPresences |
 		discordgo.IntentGuildMembers |
-		discordgo.IntentGuildVoiceStates
+		discordgo.IntentGuildVoiceStates |
+		discordgo.IntentDirectMessages
 
-	dg.AddHandler(handlers.VoiceStateUpdate)
+	// Dispatch events in the order they are received, handlers hand slow work to the pipeline
+	dg.SyncEvents = true
+
+	dm := models.NewAuthenticatedDiscordMetricsClient()
+	t := tracker.NewTracker(dm.Rules)
+	p := pipeline.NewPipeline(8, 100)
+	p.Start(ctx)
+	h := handlers.NewHandler(dm, t, p)
+
+	// The tracker keeps an in-memory model of voice channels and presence from gateway events
+	t.Register(dg)
+	dg.AddHandler(h.VoiceStateUpdate)
+	dg.AddHandler(h.GuildCreate)
+	dg.AddHandler(h.GuildRoleCreate)
+	dg.AddHandler(h.GuildRoleUpdate)
+	dg.AddHandler(h.InteractionCreate)
+	dg.AddHandler(h.MessageCreate)
 
 	err = dg.Open()
 	if err != nil {
@@ -39,13 +59,19 @@ func main() {
 		return
 	}
 
+	// Commands are registered globally so they also work in DMs with the bot
+	_, err = dg.ApplicationCommandBulkOverwrite(dg.State.User.ID, "", handlers.Commands)
+	if err != nil {
+		log.Println("error registering commands:", err)
+	}
+
 	log.Println("Discord Bot is now running.")
 
-	// Launch a goroutine to update user presence when the bot starts
-	dm := models.NewAuthenticatedDiscordMetricsClient()
-	go dm.LogUsersPresence(dg)
+	// Give and take the reward roles as the stats change
+	rewarder := rewards.NewRewarder(rewards.ConfigFromEnv(), dm, t)
+	go rewarder.Start(ctx, dg)
 
-	// Update user presence every 30 seconds
+	// Log user presence when it changes and every 30 seconds as a heartbeat, with the names that changed
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 	go func() {
@@ -53,8 +79,20 @@ func main() {
 			select {
 			case <-ctx.Done():
 				return
+			case guildID := <-t.Changes():
+				err := dm.LogGuildPresence(t, guildID)
+				if err != nil {
+					log.Println("error logging users presence:", err)
+				}
 			case <-ticker.C:
-				dm.LogUsersPresence(dg)
+				err := dm.LogUsersPresence(t)
+				if err != nil {
+					log.Println("error logging users presence:", err)
+				}
+				err = dm.LogUserNames(t)
+				if err != nil {
+					log.Println("error logging user names:", err)
+				}
 			}
 		}
 	}()
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/models"
+)
+
+// migrate upgrades the data stored in InfluxDB to the schema the bots expect.
+// Run it with -dry-run first to see what would be rewritten.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the changes without writing or deleting anything")
+	flag.Parse()
+
+	dm := models.NewAuthenticatedDiscordMetricsClient()
+	defer dm.Client.Close()
+
+	err := dm.Migrate(*dryRun)
+	if err != nil {
+		log.Fatalf("error migrating: %v", err)
+	}
+	log.Println("Migrations done")
+}
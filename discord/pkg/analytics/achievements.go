@@ -0,0 +1,152 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+type Achievement struct {
+	ID          string
+	Emoji       string
+	Name        string
+	Description string
+}
+
+var (
+	Streak7     = Achievement{"streak_7", "🔥", "On a roll", "On call 7 days in a row"}
+	Streak30    = Achievement{"streak_30", "☄️", "Unstoppable", "On call 30 days in a row"}
+	FirstIn10   = Achievement{"first_in_10", "🐓", "Early bird", "First to join an empty server 10 times"}
+	FirstIn50   = Achievement{"first_in_50", "🚪", "Door opener", "First to join an empty server 50 times"}
+	NightOwl    = Achievement{"night_owl", "🦉", "Night owl", "On call at 3 in the morning on 10 nights"}
+	Marathon    = Achievement{"marathon", "🏃", "Marathon", "A session over 5 hours"}
+	FirstStream = Achievement{"first_stream", "📺", "Broadcaster", "Streamed for the first time"}
+
+	// Achievements are listed in this order
+	Achievements = []Achievement{Streak7, Streak30, FirstIn10, FirstIn50, NightOwl, Marathon, FirstStream}
+)
+
+const (
+	marathonLength = 5 * time.Hour
+	nightOwlHour   = 3
+	nightOwlNights = 10
+)
+
+// AchievementByID returns the achievement with the ID, false when it's unknown
+func AchievementByID(id string) (Achievement, bool) {
+	for _, achievement := range Achievements {
+		if achievement.ID == id {
+			return achievement, true
+		}
+	}
+	return Achievement{}, false
+}
+
+// Unlock is an achievement a user unlocked, At is when they met its condition
+type Unlock struct {
+	Achievement
+	Person
+	At time.Time
+}
+
+// Unlocks returns the achievements every user unlocked in the voice and streaming sessions, once per user
+// and achievement, sorted by unlock time. Days are split in the given time zone. Sessions starting at from
+// were cut to a range that starts there, they were open before it so they aren't first in, a zero from cuts none.
+func Unlocks(voice, streaming []sessions.Session, from time.Time, loc *time.Location) []Unlock {
+	voice = append([]sessions.Session(nil), voice...)
+	sort.Slice(voice, func(i, j int) bool {
+		return voice[i].Start.Before(voice[j].Start)
+	})
+
+	type unlockKey struct{ user, achievement string }
+	unlocked := map[unlockKey]Unlock{}
+	unlock := func(s sessions.Session, achievement Achievement, at time.Time) {
+		key := unlockKey{userKey(s), achievement.ID}
+		if current, ok := unlocked[key]; !ok || at.Before(current.At) {
+			unlocked[key] = Unlock{Achievement: achievement, Person: person(s), At: at}
+		}
+	}
+
+	type userDays struct {
+		last   time.Time
+		streak int
+	}
+	streaks := map[string]*userDays{}
+	firstIns := map[string]int{}
+	nights := map[string]map[time.Time]bool{}
+	var active []sessions.Session
+	for _, s := range voice {
+		user := userKey(s)
+
+		if s.Duration() > marathonLength {
+			unlock(s, Marathon, s.Start.Add(marathonLength))
+		}
+
+		// First in when nobody else was on call in the server
+		stillActive := active[:0]
+		for _, other := range active {
+			if other.End.After(s.Start) {
+				stillActive = append(stillActive, other)
+			}
+		}
+		active = stillActive
+		if len(active) == 0 && (from.IsZero() || !s.Start.Equal(from)) {
+			firstIns[user]++
+			switch firstIns[user] {
+			case 10:
+				unlock(s, FirstIn10, s.Start)
+			case 50:
+				unlock(s, FirstIn50, s.Start)
+			}
+		}
+		active = append(active, s)
+
+		SplitByHour(s.Start, s.End, loc, func(hour time.Time, d time.Duration) {
+			day := time.Date(hour.Year(), hour.Month(), hour.Day(), 0, 0, 0, 0, loc)
+			days, ok := streaks[user]
+			if !ok {
+				days = &userDays{}
+				streaks[user] = days
+			}
+			switch {
+			case !day.After(days.last):
+			case days.last.AddDate(0, 0, 1).Equal(day):
+				days.last, days.streak = day, days.streak+1
+			default:
+				days.last, days.streak = day, 1
+			}
+			if days.streak >= 7 {
+				unlock(s, Streak7, hour)
+			}
+			if days.streak >= 30 {
+				unlock(s, Streak30, hour)
+			}
+
+			if hour.Hour() == nightOwlHour {
+				if nights[user] == nil {
+					nights[user] = map[time.Time]bool{}
+				}
+				nights[user][day] = true
+				if len(nights[user]) >= nightOwlNights {
+					unlock(s, NightOwl, hour)
+				}
+			}
+		})
+	}
+	for _, s := range streaming {
+		unlock(s, FirstStream, s.Start)
+	}
+
+	unlocks := make([]Unlock, 0, len(unlocked))
+	for _, u := range unlocked {
+		unlocks = append(unlocks, u)
+	}
+	sort.Slice(unlocks, func(i, j int) bool {
+		if !unlocks[i].At.Equal(unlocks[j].At) {
+			return unlocks[i].At.Before(unlocks[j].At)
+		}
+		return unlocks[i].ID < unlocks[j].ID
+	})
+	return unlocks
+}
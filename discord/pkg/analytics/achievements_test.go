@@ -0,0 +1,142 @@
+package analytics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+func TestUnlocks(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, newYork)
+	}
+	session := func(userID string, start time.Time, d time.Duration) sessions.Session {
+		return sessions.Session{UserID: userID, Username: "user" + userID, ChannelID: "a", EventType: "voice", Start: start, End: start.Add(d)}
+	}
+	// nightly sessions of the user from 23:30 to 00:30, starting on the day, so each one counts for two days
+	nightly := func(userID string, day time.Time, nights int) []sessions.Session {
+		var nightly []sessions.Session
+		for i := 0; i < nights; i++ {
+			start := time.Date(day.Year(), day.Month(), day.Day()+i, 23, 30, 0, 0, newYork)
+			nightly = append(nightly, session(userID, start, time.Hour))
+		}
+		return nightly
+	}
+	// daily sessions of the user at the hour, every step days
+	daily := func(userID string, day time.Time, hour, step, n int, d time.Duration) []sessions.Session {
+		var daily []sessions.Session
+		for i := 0; i < n; i++ {
+			daily = append(daily, session(userID, time.Date(day.Year(), day.Month(), day.Day()+i*step, hour, 0, 0, 0, newYork), d))
+		}
+		return daily
+	}
+	// with user 2 joining every session of user 1 half way, they're never first in
+	joined := func(first []sessions.Session) []sessions.Session {
+		all := append([]sessions.Session(nil), first...)
+		for _, s := range first {
+			all = append(all, session("2", s.Start.Add(s.Duration()/2), s.Duration()))
+		}
+		return all
+	}
+
+	tests := []struct {
+		name      string
+		voice     []sessions.Session
+		streaming []sessions.Session
+		from      time.Time
+		want      []string
+	}{
+		{
+			// Six nights over midnight cover seven days, across the day the clocks spring forward
+			name:  "streak over midnight and DST",
+			voice: nightly("1", at(time.March, 6, 0, 0), 6),
+			want:  []string{fmt.Sprintf("1 %s %v", Streak7.ID, at(time.March, 12, 0, 0))},
+		},
+		{
+			name:  "six days are no streak",
+			voice: nightly("1", at(time.March, 6, 0, 0), 5),
+			want:  nil,
+		},
+		{
+			name: "streak broken by a day",
+			voice: append(daily("1", at(time.March, 1, 0, 0), 20, 1, 4, time.Hour),
+				daily("1", at(time.March, 6, 0, 0), 20, 1, 4, time.Hour)...),
+			want: nil,
+		},
+		{
+			// The tenth night is the one the clocks fall back, a second session on a night doesn't count it twice.
+			// Nobody else is on call at 3 so they're all first in too.
+			name: "night owl",
+			voice: joined(append(daily("1", at(time.October, 16, 0, 0), 3, 2, 10, 30*time.Minute),
+				session("1", at(time.October, 16, 3, 40), 10*time.Minute))),
+			want: []string{
+				fmt.Sprintf("1 %s %v", FirstIn10.ID, at(time.November, 3, 3, 0)),
+				fmt.Sprintf("1 %s %v", NightOwl.ID, at(time.November, 3, 3, 0)),
+				fmt.Sprintf("2 %s %v", NightOwl.ID, at(time.November, 3, 3, 0)),
+			},
+		},
+		{
+			name:  "nine nights",
+			voice: joined(daily("1", at(time.October, 1, 0, 0), 3, 2, 9, 30*time.Minute)),
+			want:  nil,
+		},
+		{
+			name:  "first in ten times",
+			voice: joined(daily("1", at(time.May, 1, 0, 0), 20, 2, 10, time.Hour)),
+			want:  []string{fmt.Sprintf("1 %s %v", FirstIn10.ID, at(time.May, 19, 20, 0))},
+		},
+		{
+			name:  "first in nine times",
+			voice: joined(daily("1", at(time.May, 1, 0, 0), 20, 2, 9, time.Hour)),
+			want:  nil,
+		},
+		{
+			// Joining a server someone is already in isn't first in
+			name: "first in with others on call",
+			voice: append(joined(daily("1", at(time.May, 1, 0, 0), 20, 2, 9, time.Hour)),
+				session("3", at(time.May, 20, 18, 0), 3*time.Hour), session("1", at(time.May, 20, 19, 0), time.Hour)),
+			want: nil,
+		},
+		{
+			name: "session open when the range starts",
+			voice: append(joined(daily("1", at(time.May, 2, 0, 0), 20, 2, 9, time.Hour)),
+				session("1", at(time.May, 1, 0, 0), time.Hour)),
+			want: []string{fmt.Sprintf("1 %s %v", FirstIn10.ID, at(time.May, 18, 20, 0))},
+		},
+		{
+			// The same session cut to a range starting at it was open before, it isn't first in
+			name: "session cut to the range",
+			voice: append(joined(daily("1", at(time.May, 2, 0, 0), 20, 2, 9, time.Hour)),
+				session("1", at(time.May, 1, 0, 0), time.Hour)),
+			from: at(time.May, 1, 0, 0),
+			want: nil,
+		},
+		{
+			name:      "marathon and first stream",
+			voice:     []sessions.Session{session("1", at(time.June, 1, 12, 0), 6*time.Hour), session("2", at(time.June, 1, 13, 0), 5*time.Hour)},
+			streaming: []sessions.Session{session("2", at(time.June, 1, 14, 0), time.Hour), session("2", at(time.June, 2, 14, 0), time.Hour)},
+			want: []string{
+				fmt.Sprintf("2 %s %v", FirstStream.ID, at(time.June, 1, 14, 0)),
+				fmt.Sprintf("1 %s %v", Marathon.ID, at(time.June, 1, 17, 0)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, unlock := range Unlocks(tt.voice, tt.streaming, tt.from, newYork) {
+				got = append(got, fmt.Sprintf("%s %s %v", unlock.UserID, unlock.ID, unlock.At.In(newYork)))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// Pair is the time two users spent in the same channel at the same time
+type Pair struct {
+	A       Person
+	B       Person
+	Overlap time.Duration
+}
+
+type Buddy struct {
+	Person
+	Overlap time.Duration
+}
+
+// CoPresence computes the overlap of every pair of users from their voice sessions, largest overlap first
+func CoPresence(voiceSessions []sessions.Session) []Pair {
+	byChannel := map[string][]sessions.Session{}
+	for _, s := range voiceSessions {
+		byChannel[s.ChannelID] = append(byChannel[s.ChannelID], s)
+	}
+
+	type pairKey struct{ a, b string }
+	people := map[string]Person{}
+	overlaps := map[pairKey]time.Duration{}
+	for _, channelSessions := range byChannel {
+		sort.Slice(channelSessions, func(i, j int) bool {
+			return channelSessions[i].Start.Before(channelSessions[j].Start)
+		})
+
+		var active []sessions.Session
+		for _, s := range channelSessions {
+			people[userKey(s)] = person(s)
+
+			// Drop the sessions that ended before this one started
+			stillActive := active[:0]
+			for _, other := range active {
+				if other.End.After(s.Start) {
+					stillActive = append(stillActive, other)
+				}
+			}
+			active = stillActive
+
+			for _, other := range active {
+				a, b := userKey(other), userKey(s)
+				if a == b {
+					continue
+				}
+				if a > b {
+					a, b = b, a
+				}
+				end := s.End
+				if other.End.Before(end) {
+					end = other.End
+				}
+				overlaps[pairKey{a, b}] += end.Sub(s.Start)
+			}
+			active = append(active, s)
+		}
+	}
+
+	pairs := make([]Pair, 0, len(overlaps))
+	for key, overlap := range overlaps {
+		pairs = append(pairs, Pair{A: people[key.a], B: people[key.b], Overlap: overlap})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Overlap != pairs[j].Overlap {
+			return pairs[i].Overlap > pairs[j].Overlap
+		}
+		return pairs[i].A.Username+pairs[i].B.Username < pairs[j].A.Username+pairs[j].B.Username
+	})
+	return pairs
+}
+
+// Buddies returns the people the user overlapped with, most time together first, n <= 0 returns everyone
+func Buddies(pairs []Pair, user string, n int) []Buddy {
+	var buddies []Buddy
+	for _, pair := range pairs {
+		switch {
+		case pair.A.Is(user):
+			buddies = append(buddies, Buddy{Person: pair.B, Overlap: pair.Overlap})
+		case pair.B.Is(user):
+			buddies = append(buddies, Buddy{Person: pair.A, Overlap: pair.Overlap})
+		}
+	}
+	if n > 0 && len(buddies) > n {
+		buddies = buddies[:n]
+	}
+	return buddies
+}
+
+type graphNode struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+}
+
+type graphEdge struct {
+	Source  string  `json:"source"`
+	Target  string  `json:"target"`
+	Minutes float64 `json:"minutes"`
+}
+
+type graph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+// WriteGraphJSON writes the pairs as a JSON graph of nodes and edges weighted by shared minutes
+func WriteGraphJSON(w io.Writer, pairs []Pair) error {
+	g := graph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+	seen := map[string]bool{}
+	for _, pair := range pairs {
+		for _, p := range []Person{pair.A, pair.B} {
+			if !seen[p.id()] {
+				seen[p.id()] = true
+				g.Nodes = append(g.Nodes, graphNode{ID: p.id(), Username: p.Username, DisplayName: p.DisplayName})
+			}
+		}
+		g.Edges = append(g.Edges, graphEdge{Source: pair.A.id(), Target: pair.B.id(), Minutes: pair.Overlap.Minutes()})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g)
+}
+
+// WriteGraphDOT writes the pairs as an undirected Graphviz graph with edges weighted by shared minutes
+func WriteGraphDOT(w io.Writer, pairs []Pair) error {
+	var b strings.Builder
+	b.WriteString("graph buddies {\n")
+	seen := map[string]bool{}
+	for _, pair := range pairs {
+		for _, p := range []Person{pair.A, pair.B} {
+			if !seen[p.id()] {
+				seen[p.id()] = true
+				b.WriteString(fmt.Sprintf("  %s [label=%s];\n", dotQuote(p.id()), dotQuote(p.DisplayName)))
+			}
+		}
+	}
+	for _, pair := range pairs {
+		minutes := int(pair.Overlap.Minutes())
+		b.WriteString(fmt.Sprintf("  %s -- %s [weight=%d, label=\"%dm\"];\n", dotQuote(pair.A.id()), dotQuote(pair.B.id()), minutes, minutes))
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (p Person) id() string {
+	if p.UserID != "" {
+		return p.UserID
+	}
+	return p.Username
+}
+
+func dotQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(value) + `"`
+}
@@ -0,0 +1,113 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+type ChannelStats struct {
+	ChannelID      string
+	ChannelName    string
+	Occupied       time.Duration     // Time with at least one user in the channel
+	UserTime       time.Duration     // Sum of the time of every user
+	AverageUsers   float64           // Average concurrent users while occupied
+	PeakUsers      int               // Most concurrent users
+	PeakAt         time.Time         // First time the peak was reached
+	HourlyUserTime [24]time.Duration // User time per hour of the day
+	TopUsers       []LeaderboardEntry
+}
+
+// Channel computes the occupancy stats of the sessions of a channel, hours of the day are in the given time zone
+func Channel(channelSessions []sessions.Session, loc *time.Location, topUsers int) ChannelStats {
+	stats := ChannelStats{
+		TopUsers: Leaderboard(channelSessions, topUsers),
+	}
+	if len(channelSessions) == 0 {
+		return stats
+	}
+	last := channelSessions[len(channelSessions)-1]
+	stats.ChannelID = last.ChannelID
+	stats.ChannelName = last.ChannelName
+
+	for _, s := range channelSessions {
+		stats.UserTime += s.Duration()
+		SplitByHour(s.Start, s.End, loc, func(hour time.Time, d time.Duration) {
+			stats.HourlyUserTime[hour.Hour()] += d
+		})
+	}
+
+	Concurrency(channelSessions, func(start, end time.Time, users int) {
+		if users > 0 {
+			stats.Occupied += end.Sub(start)
+		}
+		if users > stats.PeakUsers {
+			stats.PeakUsers = users
+			stats.PeakAt = start
+		}
+	})
+	if stats.Occupied > 0 {
+		stats.AverageUsers = float64(stats.UserTime) / float64(stats.Occupied)
+	}
+	return stats
+}
+
+// BusiestHours returns the hours of the day with the most user time, busiest first
+func (c ChannelStats) BusiestHours(n int) []int {
+	hours := make([]int, 0, 24)
+	for hour, d := range c.HourlyUserTime {
+		if d > 0 {
+			hours = append(hours, hour)
+		}
+	}
+	sort.SliceStable(hours, func(i, j int) bool {
+		return c.HourlyUserTime[hours[i]] > c.HourlyUserTime[hours[j]]
+	})
+	if len(hours) > n {
+		hours = hours[:n]
+	}
+	return hours
+}
+
+// Concurrency walks the sessions in time order and calls fn for every span with a constant number of users
+func Concurrency(userSessions []sessions.Session, fn func(start, end time.Time, users int)) {
+	type edge struct {
+		at    time.Time
+		delta int
+	}
+	edges := make([]edge, 0, 2*len(userSessions))
+	for _, s := range userSessions {
+		edges = append(edges, edge{s.Start, 1}, edge{s.End, -1})
+	}
+	// Leaves go first on ties so back to back sessions don't count as concurrent
+	sort.Slice(edges, func(i, j int) bool {
+		if !edges[i].at.Equal(edges[j].at) {
+			return edges[i].at.Before(edges[j].at)
+		}
+		return edges[i].delta < edges[j].delta
+	})
+
+	users := 0
+	for i, e := range edges {
+		users += e.delta
+		if i+1 < len(edges) && edges[i+1].at.After(e.at) {
+			fn(e.at, edges[i+1].at, users)
+		}
+	}
+}
+
+// SplitByHour calls fn with the start of every clock hour in the time zone the span covers and the time spent in it
+func SplitByHour(start, end time.Time, loc *time.Location, fn func(hour time.Time, d time.Duration)) {
+	for current := start.In(loc); current.Before(end); {
+		// Truncating by the clock instead of time.Date keeps the repeated hour of a DST fall-back, time.Date would
+		// resolve it to the first occurrence and never get past it
+		hour := current.Add(-time.Duration(current.Minute())*time.Minute - time.Duration(current.Second())*time.Second - time.Duration(current.Nanosecond()))
+		next := hour.Add(time.Hour)
+		if next.After(end) {
+			next = end
+		}
+		fn(hour, next.Sub(current))
+		current = next.In(loc)
+	}
+}
@@ -0,0 +1,65 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitByHour(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+		end   time.Time
+		loc   *time.Location
+		hours int
+	}{
+		{"within an hour", time.Date(2024, 6, 1, 10, 15, 0, 0, newYork), time.Date(2024, 6, 1, 10, 45, 0, 0, newYork), newYork, 1},
+		{"across hours", time.Date(2024, 6, 1, 10, 15, 0, 0, newYork), time.Date(2024, 6, 1, 13, 5, 0, 0, newYork), newYork, 4},
+		{"fall-back New York", time.Date(2024, 11, 3, 0, 30, 0, 0, newYork), time.Date(2024, 11, 3, 4, 30, 0, 0, newYork), newYork, 6},
+		{"fall-back Sao Paulo", time.Date(2019, 2, 16, 22, 10, 0, 0, saoPaulo), time.Date(2019, 2, 17, 1, 10, 0, 0, saoPaulo), saoPaulo, 5},
+		{"spring-forward New York", time.Date(2024, 3, 10, 0, 30, 0, 0, newYork), time.Date(2024, 3, 10, 4, 30, 0, 0, newYork), newYork, 4},
+		{"half hour offset", time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC), time.Date(2024, 6, 1, 11, 15, 0, 0, time.UTC), kolkata, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total time.Duration
+			var hours []time.Time
+			SplitByHour(tt.start, tt.end, tt.loc, func(hour time.Time, d time.Duration) {
+				if len(hours) > 100 {
+					t.Fatalf("no progress after %d hours", len(hours))
+				}
+				if hour.Minute() != 0 || hour.Second() != 0 || hour.Nanosecond() != 0 {
+					t.Errorf("hour %v doesn't start on the clock hour", hour)
+				}
+				if d <= 0 || d > time.Hour {
+					t.Errorf("hour %v has %v", hour, d)
+				}
+				if len(hours) > 0 && !hour.After(hours[len(hours)-1]) {
+					t.Errorf("hour %v doesn't come after %v", hour, hours[len(hours)-1])
+				}
+				hours = append(hours, hour)
+				total += d
+			})
+			if total != tt.end.Sub(tt.start) {
+				t.Errorf("total is %v, want %v", total, tt.end.Sub(tt.start))
+			}
+			if len(hours) != tt.hours {
+				t.Errorf("got %d hours %v, want %d", len(hours), hours, tt.hours)
+			}
+		})
+	}
+}
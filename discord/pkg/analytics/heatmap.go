@@ -0,0 +1,69 @@
+package analytics
+
+import (
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// Point is a value of a time series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// HourOfWeek is a 7x24 grid indexed by day of the week, starting on Monday, and hour of the day
+type HourOfWeek [7][24]float64
+
+// Max returns the largest value of the grid
+func (h HourOfWeek) Max() float64 {
+	var max float64
+	for _, day := range h {
+		for _, value := range day {
+			if value > max {
+				max = value
+			}
+		}
+	}
+	return max
+}
+
+// weekday returns the day of the week with Monday as 0
+func weekday(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+// AverageByHourOfWeek averages hourly points of a series, such as the on call users, into each hour of the week
+func AverageByHourOfWeek(points []Point, loc *time.Location) HourOfWeek {
+	var sums HourOfWeek
+	var counts [7][24]int
+	for _, p := range points {
+		t := p.Time.In(loc)
+		sums[weekday(t)][t.Hour()] += p.Value
+		counts[weekday(t)][t.Hour()]++
+	}
+
+	var grid HourOfWeek
+	for day := range grid {
+		for hour := range grid[day] {
+			if counts[day][hour] > 0 {
+				grid[day][hour] = sums[day][hour] / float64(counts[day][hour])
+			}
+		}
+	}
+	return grid
+}
+
+// PresenceByHourOfWeek returns the share of each hour of the week covered by the sessions over the given number of weeks
+func PresenceByHourOfWeek(userSessions []sessions.Session, weeks int, loc *time.Location) HourOfWeek {
+	var grid HourOfWeek
+	if weeks <= 0 {
+		return grid
+	}
+	for _, s := range userSessions {
+		SplitByHour(s.Start, s.End, loc, func(hour time.Time, d time.Duration) {
+			grid[weekday(hour)][hour.Hour()] += d.Hours() / float64(weeks)
+		})
+	}
+	return grid
+}
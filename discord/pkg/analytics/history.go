@@ -0,0 +1,44 @@
+package analytics
+
+import (
+	"sort"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+type HistoryEntry struct {
+	sessions.Session
+	Streamed bool
+	Webcam   bool
+}
+
+// History returns the latest n voice sessions, latest first, with whether the user streamed
+// or had their webcam on in the same channel during them
+func History(voice, streaming, webcam []sessions.Session, n int) []HistoryEntry {
+	latest := append([]sessions.Session(nil), voice...)
+	sort.Slice(latest, func(i, j int) bool {
+		return latest[i].Start.After(latest[j].Start)
+	})
+	if n > 0 && len(latest) > n {
+		latest = latest[:n]
+	}
+
+	entries := make([]HistoryEntry, 0, len(latest))
+	for _, v := range latest {
+		entries = append(entries, HistoryEntry{
+			Session:  v,
+			Streamed: overlapsInChannel(v, streaming),
+			Webcam:   overlapsInChannel(v, webcam),
+		})
+	}
+	return entries
+}
+
+func overlapsInChannel(s sessions.Session, others []sessions.Session) bool {
+	for _, other := range others {
+		if other.UserID == s.UserID && other.ChannelID == s.ChannelID && other.Start.Before(s.End) && other.End.After(s.Start) {
+			return true
+		}
+	}
+	return false
+}
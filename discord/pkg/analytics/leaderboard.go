@@ -0,0 +1,86 @@
+// Package analytics aggregates rebuilt voice sessions into the stats shown by the bots.
+package analytics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+type Person struct {
+	UserID      string
+	Username    string
+	DisplayName string
+}
+
+type LeaderboardEntry struct {
+	Person
+	Total    time.Duration
+	Sessions int
+}
+
+// Leaderboard ranks users by the total duration of their sessions, n <= 0 returns everyone
+func Leaderboard(userSessions []sessions.Session, n int) []LeaderboardEntry {
+	byUser := map[string]*LeaderboardEntry{}
+	for _, s := range userSessions {
+		key := userKey(s)
+		entry, ok := byUser[key]
+		if !ok {
+			entry = &LeaderboardEntry{}
+			byUser[key] = entry
+		}
+		// Sessions are sorted by start, keep the latest names
+		entry.Person = person(s)
+		entry.Total += s.Duration()
+		entry.Sessions++
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(byUser))
+	for _, entry := range byUser {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Total != entries[j].Total {
+			return entries[i].Total > entries[j].Total
+		}
+		return entries[i].Username < entries[j].Username
+	})
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// InChannel returns the sessions in the channel, matched by ID or case insensitive name
+func InChannel(userSessions []sessions.Session, channel string) []sessions.Session {
+	filtered := make([]sessions.Session, 0, len(userSessions))
+	for _, s := range userSessions {
+		if s.ChannelID == channel || strings.EqualFold(s.ChannelName, channel) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+func person(s sessions.Session) Person {
+	return Person{
+		UserID:      s.UserID,
+		Username:    s.Username,
+		DisplayName: s.DisplayName,
+	}
+}
+
+// Is reports whether the person is the user given by ID, username or display name, names are case insensitive
+func (p Person) Is(user string) bool {
+	return p.UserID == user || strings.EqualFold(p.Username, user) || strings.EqualFold(p.DisplayName, user)
+}
+
+// userKey identifies the user of a session, events stored without an ID fall back to the username
+func userKey(s sessions.Session) string {
+	if s.UserID != "" {
+		return s.UserID
+	}
+	return s.Username
+}
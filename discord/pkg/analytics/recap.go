@@ -0,0 +1,63 @@
+package analytics
+
+import (
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// nightEndHour is when nights end, so late calls count for the evening they started
+const nightEndHour = 6
+
+// Recap sums up the voice sessions of a period
+type Recap struct {
+	Total             time.Duration
+	Sessions          int
+	People            int
+	Top               []LeaderboardEntry
+	Longest           sessions.Session // Zero without sessions
+	BusiestNight      time.Time        // Midnight of the evening the busiest night started, zero without sessions
+	BusiestNightTotal time.Duration
+}
+
+// NewRecap sums up the voice sessions, nights are split in the given time zone
+func NewRecap(voice []sessions.Session, loc *time.Location, top int) Recap {
+	recap := Recap{Sessions: len(voice)}
+	leaderboard := Leaderboard(voice, 0)
+	recap.People = len(leaderboard)
+	recap.Top = leaderboard
+	if top > 0 && len(recap.Top) > top {
+		recap.Top = recap.Top[:top]
+	}
+
+	nights := map[time.Time]time.Duration{}
+	for _, s := range voice {
+		recap.Total += s.Duration()
+		if s.Duration() > recap.Longest.Duration() {
+			recap.Longest = s
+		}
+		for start := s.Start.In(loc); start.Before(s.End); {
+			night := nightOf(start)
+			end := time.Date(night.Year(), night.Month(), night.Day()+1, nightEndHour, 0, 0, 0, loc)
+			if s.End.Before(end) {
+				end = s.End.In(loc)
+			}
+			nights[night] += end.Sub(start)
+			start = end
+		}
+	}
+	for night, total := range nights {
+		if total > recap.BusiestNightTotal || (total == recap.BusiestNightTotal && night.Before(recap.BusiestNight)) {
+			recap.BusiestNight, recap.BusiestNightTotal = night, total
+		}
+	}
+	return recap
+}
+
+// nightOf returns midnight of the evening the night of the time started
+func nightOf(t time.Time) time.Time {
+	if t.Hour() < nightEndHour {
+		t = t.AddDate(0, 0, -1)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,33 @@
+package analytics
+
+import (
+	"strings"
+	"time"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders the values of the points as a line of block characters scaled to the largest one
+func Sparkline(points []Point) string {
+	var max float64
+	for _, p := range points {
+		if p.Value > max {
+			max = p.Value
+		}
+	}
+
+	var b strings.Builder
+	for _, p := range points {
+		level := 0
+		if max > 0 {
+			level = int(p.Value / max * float64(len(sparks)-1))
+		}
+		b.WriteRune(sparks[level])
+	}
+	return b.String()
+}
+
+// Usual returns the historical average of the grid for the hour of the week of the time
+func (h HourOfWeek) Usual(t time.Time) float64 {
+	return h[weekday(t)][t.Hour()]
+}
@@ -0,0 +1,109 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+const wrappedTop = 3
+
+// Wrapped is the year in review of the server, or of a user when built for one
+type Wrapped struct {
+	Total                time.Duration
+	Sessions             int
+	Streaming            time.Duration
+	Webcam               time.Duration
+	Longest              sessions.Session
+	FavoriteChannel      string
+	FavoriteChannelTotal time.Duration
+	BusiestMonth         time.Time // First day of the month, zero without sessions
+	BusiestMonthTotal    time.Duration
+	ActiveDays           int
+	LongestStreak        int                // Consecutive days with someone on call
+	LongestStreakEnd     time.Time          // Last day of the longest streak
+	Buddies              []Buddy            // Of the user
+	Duos                 []Pair             // Of the server
+	Top                  []LeaderboardEntry // Of the server
+	People               int
+}
+
+// NewWrapped sums up the voice, streaming and webcam sessions of the year of the server for the user given by ID,
+// or for the whole server when the ID is empty. Days and months are split in the given time zone.
+func NewWrapped(allVoice, streaming, webcam []sessions.Session, userID string, loc *time.Location) Wrapped {
+	ofWrapped := func(userSessions []sessions.Session) []sessions.Session {
+		if userID == "" {
+			return userSessions
+		}
+		var mine []sessions.Session
+		for _, s := range userSessions {
+			if userKey(s) == userID {
+				mine = append(mine, s)
+			}
+		}
+		return mine
+	}
+
+	voice := ofWrapped(allVoice)
+	wrapped := Wrapped{
+		Sessions:  len(voice),
+		Streaming: sessions.Total(ofWrapped(streaming)),
+		Webcam:    sessions.Total(ofWrapped(webcam)),
+	}
+	if userID == "" {
+		wrapped.Top = Leaderboard(voice, wrappedTop)
+		wrapped.People = len(Leaderboard(voice, 0))
+		wrapped.Duos = CoPresence(allVoice)
+		if len(wrapped.Duos) > wrappedTop {
+			wrapped.Duos = wrapped.Duos[:wrappedTop]
+		}
+	} else {
+		wrapped.Buddies = Buddies(CoPresence(allVoice), userID, wrappedTop)
+	}
+
+	channels := map[string]time.Duration{}
+	months := map[time.Time]time.Duration{}
+	days := map[time.Time]bool{}
+	for _, s := range voice {
+		wrapped.Total += s.Duration()
+		if s.Duration() > wrapped.Longest.Duration() {
+			wrapped.Longest = s
+		}
+		channels[s.ChannelName] += s.Duration()
+		SplitByHour(s.Start, s.End, loc, func(hour time.Time, d time.Duration) {
+			months[time.Date(hour.Year(), hour.Month(), 1, 0, 0, 0, 0, loc)] += d
+			days[time.Date(hour.Year(), hour.Month(), hour.Day(), 0, 0, 0, 0, loc)] = true
+		})
+	}
+	for channel, total := range channels {
+		if total > wrapped.FavoriteChannelTotal || (total == wrapped.FavoriteChannelTotal && channel < wrapped.FavoriteChannel) {
+			wrapped.FavoriteChannel, wrapped.FavoriteChannelTotal = channel, total
+		}
+	}
+	for month, total := range months {
+		if total > wrapped.BusiestMonthTotal || (total == wrapped.BusiestMonthTotal && month.Before(wrapped.BusiestMonth)) {
+			wrapped.BusiestMonth, wrapped.BusiestMonthTotal = month, total
+		}
+	}
+
+	activeDays := make([]time.Time, 0, len(days))
+	for day := range days {
+		activeDays = append(activeDays, day)
+	}
+	sort.Slice(activeDays, func(i, j int) bool {
+		return activeDays[i].Before(activeDays[j])
+	})
+	wrapped.ActiveDays = len(activeDays)
+	streak := 0
+	for i, day := range activeDays {
+		streak++
+		if i > 0 && !activeDays[i-1].AddDate(0, 0, 1).Equal(day) {
+			streak = 1
+		}
+		if streak > wrapped.LongestStreak {
+			wrapped.LongestStreak, wrapped.LongestStreakEnd = streak, day
+		}
+	}
+	return wrapped
+}
@@ -0,0 +1,104 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+func TestNewWrapped(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := func(userID, channel string, start time.Time, hours int) sessions.Session {
+		return sessions.Session{UserID: userID, Username: "user" + userID, ChannelID: channel, ChannelName: channel, EventType: "voice",
+			Start: start, End: start.Add(time.Duration(hours) * time.Hour)}
+	}
+	day := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, newYork)
+	}
+
+	var voice []sessions.Session
+	// Every evening from March 29 to April 2, a streak across the month
+	for d := day(time.March, 29, 20); d.Before(day(time.April, 3, 0)); d = d.AddDate(0, 0, 1) {
+		voice = append(voice, session("1", "General", d, 1))
+	}
+	voice = append(voice,
+		session("1", "General", day(time.April, 20, 18), 1),
+		// Over midnight and into May, 2h in April and 2h in May
+		session("1", "Gaming", day(time.April, 30, 22), 4),
+		session("2", "Gaming", day(time.May, 5, 10), 4),
+	)
+	streaming := []sessions.Session{session("1", "General", day(time.April, 20, 18), 1)}
+
+	tests := []struct {
+		name   string
+		userID string
+		loc    *time.Location
+		want   Wrapped
+	}{
+		{
+			name: "server",
+			loc:  newYork,
+			want: Wrapped{
+				Total: 14 * time.Hour, Sessions: 8, Streaming: time.Hour,
+				FavoriteChannel: "Gaming", FavoriteChannelTotal: 8 * time.Hour,
+				BusiestMonth: day(time.May, 1, 0), BusiestMonthTotal: 6 * time.Hour,
+				ActiveDays: 9, LongestStreak: 5, LongestStreakEnd: day(time.April, 2, 0),
+				People: 2,
+			},
+		},
+		{
+			name:   "user",
+			userID: "1",
+			loc:    newYork,
+			want: Wrapped{
+				Total: 10 * time.Hour, Sessions: 7, Streaming: time.Hour,
+				FavoriteChannel: "General", FavoriteChannelTotal: 6 * time.Hour,
+				BusiestMonth: day(time.April, 1, 0), BusiestMonthTotal: 5 * time.Hour,
+				ActiveDays: 8, LongestStreak: 5, LongestStreakEnd: day(time.April, 2, 0),
+			},
+		},
+		{
+			// The evenings in New York are past midnight in UTC, April and May tie at 4h and the earlier month wins
+			name:   "user in UTC",
+			userID: "1",
+			loc:    time.UTC,
+			want: Wrapped{
+				Total: 10 * time.Hour, Sessions: 7, Streaming: time.Hour,
+				FavoriteChannel: "General", FavoriteChannelTotal: 6 * time.Hour,
+				BusiestMonth: time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC), BusiestMonthTotal: 4 * time.Hour,
+				ActiveDays: 7, LongestStreak: 5, LongestStreakEnd: time.Date(2024, time.April, 3, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewWrapped(voice, streaming, nil, tt.userID, tt.loc)
+			want := tt.want
+			if got.Total != want.Total || got.Sessions != want.Sessions || got.Streaming != want.Streaming || got.Webcam != 0 {
+				t.Errorf("totals are %v in %d sessions, %v streaming, want %v in %d sessions, %v streaming",
+					got.Total, got.Sessions, got.Streaming, want.Total, want.Sessions, want.Streaming)
+			}
+			if got.Longest.Duration() != 4*time.Hour || !got.Longest.Start.Equal(day(time.April, 30, 22)) {
+				t.Errorf("longest session is %v at %v", got.Longest.Duration(), got.Longest.Start)
+			}
+			if got.FavoriteChannel != want.FavoriteChannel || got.FavoriteChannelTotal != want.FavoriteChannelTotal {
+				t.Errorf("favorite channel is %s with %v, want %s with %v", got.FavoriteChannel, got.FavoriteChannelTotal, want.FavoriteChannel, want.FavoriteChannelTotal)
+			}
+			if !got.BusiestMonth.Equal(want.BusiestMonth) || got.BusiestMonthTotal != want.BusiestMonthTotal {
+				t.Errorf("busiest month is %v with %v, want %v with %v", got.BusiestMonth, got.BusiestMonthTotal, want.BusiestMonth, want.BusiestMonthTotal)
+			}
+			if got.ActiveDays != want.ActiveDays || got.LongestStreak != want.LongestStreak || !got.LongestStreakEnd.Equal(want.LongestStreakEnd) {
+				t.Errorf("%d active days and a %d day streak to %v, want %d active days and a %d day streak to %v",
+					got.ActiveDays, got.LongestStreak, got.LongestStreakEnd, want.ActiveDays, want.LongestStreak, want.LongestStreakEnd)
+			}
+			if got.People != want.People {
+				t.Errorf("%d people, want %d", got.People, want.People)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package filters
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Subject describes a member and the channel they are in, as far as it is known.
+// Stats built from stored events only know the tags written with them, so every
+// field is optional and empty fields never match a rule.
+type Subject struct {
+	UserID       string
+	Username     string
+	DisplayName  string
+	Bot          bool
+	RoleIDs      []string
+	HasRoles     bool // Events stored before roles were tracked don't know the member roles
+	ChannelID    string
+	ChannelName  string
+	CategoryID   string
+	CategoryName string
+}
+
+// Rules is the single set of filtering rules applied to voice events, user counts and stats.
+type Rules struct {
+	UserIDs        []string
+	Usernames      []string // Matched against both the username and the display name
+	RoleIDs        []string
+	TrackedRoleIDs []string // When set, only members with one of these roles are tracked
+	Channels       []string // Matched against both the channel ID and the channel name
+	Categories     []string // Matched against both the category ID and the category name
+	IgnoreBots     bool
+	UserPattern    *regexp.Regexp // Matched against both the username and the display name
+	ChannelPattern *regexp.Regexp // Matched against the channel name
+}
+
+// NewRulesFromEnv loads the filtering rules from the environment.
+// DISCORD_IGNORED_VOICE_TIME_COUNT_CHANNEL is still honoured and merged with DISCORD_IGNORED_CHANNELS.
+func NewRulesFromEnv() *Rules {
+	r := &Rules{
+		UserIDs:        splitList(os.Getenv("DISCORD_IGNORED_USER_IDS")),
+		Usernames:      splitList(os.Getenv("DISCORD_IGNORED_USERNAMES")),
+		RoleIDs:        splitList(os.Getenv("DISCORD_IGNORED_ROLES")),
+		TrackedRoleIDs: splitList(os.Getenv("DISCORD_TRACKED_ROLES")),
+		Channels:       splitList(os.Getenv("DISCORD_IGNORED_CHANNELS")),
+		Categories:     splitList(os.Getenv("DISCORD_IGNORED_CATEGORIES")),
+		IgnoreBots:     os.Getenv("DISCORD_IGNORE_BOTS") != "false",
+	}
+	r.Channels = append(r.Channels, splitList(os.Getenv("DISCORD_IGNORED_VOICE_TIME_COUNT_CHANNEL"))...)
+
+	if pattern := os.Getenv("DISCORD_IGNORED_USER_PATTERN"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Fatalf("DISCORD_IGNORED_USER_PATTERN is not a valid regular expression: %v", err)
+		}
+		r.UserPattern = re
+	}
+	if pattern := os.Getenv("DISCORD_IGNORED_CHANNEL_PATTERN"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Fatalf("DISCORD_IGNORED_CHANNEL_PATTERN is not a valid regular expression: %v", err)
+		}
+		r.ChannelPattern = re
+	}
+	return r
+}
+
+// Ignores reports whether the subject should be left out because of who they are or where they are.
+func (r *Rules) Ignores(s Subject) bool {
+	return r.IgnoresUser(s) || r.IgnoresChannel(s)
+}
+
+// IgnoresUser reports whether the subject should be left out because of who they are.
+func (r *Rules) IgnoresUser(s Subject) bool {
+	if r == nil {
+		return false
+	}
+	if r.IgnoreBots && s.Bot {
+		return true
+	}
+	if contains(r.UserIDs, s.UserID) {
+		return true
+	}
+	if contains(r.Usernames, s.Username) || contains(r.Usernames, s.DisplayName) {
+		return true
+	}
+	for _, roleID := range s.RoleIDs {
+		if contains(r.RoleIDs, roleID) {
+			return true
+		}
+	}
+	if len(r.TrackedRoleIDs) > 0 && s.HasRoles && !r.TracksRoles(s.RoleIDs) {
+		return true
+	}
+	if r.UserPattern != nil && (matches(r.UserPattern, s.Username) || matches(r.UserPattern, s.DisplayName)) {
+		return true
+	}
+	return false
+}
+
+// TracksRoles reports whether a member with the given roles is tracked by the role-scoped rules.
+func (r *Rules) TracksRoles(roleIDs []string) bool {
+	if r == nil || len(r.TrackedRoleIDs) == 0 {
+		return true
+	}
+	for _, roleID := range roleIDs {
+		if contains(r.TrackedRoleIDs, roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+// IgnoresChannel reports whether the subject should be left out because of the channel they are in.
+func (r *Rules) IgnoresChannel(s Subject) bool {
+	if r == nil {
+		return false
+	}
+	if contains(r.Channels, s.ChannelID) || contains(r.Channels, s.ChannelName) {
+		return true
+	}
+	if contains(r.Categories, s.CategoryID) || contains(r.Categories, s.CategoryName) {
+		return true
+	}
+	if r.ChannelPattern != nil && matches(r.ChannelPattern, s.ChannelName) {
+		return true
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	return value != "" && slices.Contains(list, value)
+}
+
+func matches(re *regexp.Regexp, value string) bool {
+	return value != "" && re.MatchString(value)
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
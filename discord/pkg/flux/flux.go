@@ -0,0 +1,202 @@
+// Package flux builds Flux queries without formatting untrusted input into the query text.
+// Every value goes through String, Time or Duration so it can only ever be a literal.
+package flux
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the value as a Flux string literal
+func String(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range strings.ToValidUTF8(value, "�") {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '$':
+			// Escaped so "${" can't start a string interpolation
+			b.WriteString(`\$`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Time returns the time as a Flux time literal
+func Time(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Duration returns the duration as a Flux duration literal
+func Duration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0s"
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	default:
+		return strconv.FormatInt(int64(d), 10) + "ns"
+	}
+}
+
+// Column returns a reference to a column of the record r in a predicate
+func Column(name string) string {
+	return "r[" + String(name) + "]"
+}
+
+// Predicate is a Flux boolean expression over the record r
+type Predicate string
+
+// Eq matches records where the column equals the string value
+func Eq(column, value string) Predicate {
+	return Predicate(Column(column) + " == " + String(value))
+}
+
+// Ne matches records where the column differs from the string value
+func Ne(column, value string) Predicate {
+	return Predicate(Column(column) + " != " + String(value))
+}
+
+// Exists matches records where the column is set
+func Exists(column string) Predicate {
+	return Predicate("exists " + Column(column))
+}
+
+// In matches records where the column equals any of the string values
+func In(column string, values ...string) Predicate {
+	predicates := make([]Predicate, 0, len(values))
+	for _, value := range values {
+		predicates = append(predicates, Eq(column, value))
+	}
+	return Or(predicates...)
+}
+
+// And matches records matched by every predicate, no predicates match everything
+func And(predicates ...Predicate) Predicate {
+	return join("and", "true", predicates)
+}
+
+// Or matches records matched by any predicate, no predicates match nothing
+func Or(predicates ...Predicate) Predicate {
+	return join("or", "false", predicates)
+}
+
+// Not matches records not matched by the predicate
+func Not(predicate Predicate) Predicate {
+	return Predicate("not (" + string(predicate) + ")")
+}
+
+func join(operator, empty string, predicates []Predicate) Predicate {
+	switch len(predicates) {
+	case 0:
+		return Predicate(empty)
+	case 1:
+		return predicates[0]
+	}
+	parts := make([]string, 0, len(predicates))
+	for _, predicate := range predicates {
+		parts = append(parts, "("+string(predicate)+")")
+	}
+	return Predicate(strings.Join(parts, " "+operator+" "))
+}
+
+// Query is a pipeline of Flux functions starting from a bucket
+type Query struct {
+	steps []string
+}
+
+func From(bucket string) *Query {
+	return &Query{
+		steps: []string{fmt.Sprintf("from(bucket: %s)", String(bucket))},
+	}
+}
+
+// Range limits the query to [start, stop), a zero stop means up to now
+func (q *Query) Range(start, stop time.Time) *Query {
+	if stop.IsZero() {
+		return q.add(fmt.Sprintf("range(start: %s)", Time(start)))
+	}
+	return q.add(fmt.Sprintf("range(start: %s, stop: %s)", Time(start), Time(stop)))
+}
+
+// RangeSince limits the query to the last given duration
+func (q *Query) RangeSince(d time.Duration) *Query {
+	return q.add(fmt.Sprintf("range(start: -%s)", Duration(d)))
+}
+
+// RangeAll doesn't limit the query in time
+func (q *Query) RangeAll() *Query {
+	return q.add("range(start: 0)")
+}
+
+func (q *Query) Filter(predicate Predicate) *Query {
+	return q.add(fmt.Sprintf("filter(fn: (r) => %s)", predicate))
+}
+
+// Pivot turns the fields of each timestamp into columns
+func (q *Query) Pivot() *Query {
+	return q.add(`pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")`)
+}
+
+// Group regroups the tables by the columns, no columns merges every table into one
+func (q *Query) Group(columns ...string) *Query {
+	return q.add(fmt.Sprintf("group(columns: %s)", list(columns)))
+}
+
+func (q *Query) Sort(desc bool, columns ...string) *Query {
+	return q.add(fmt.Sprintf("sort(columns: %s, desc: %t)", list(columns), desc))
+}
+
+func (q *Query) Limit(n int) *Query {
+	return q.add(fmt.Sprintf("limit(n: %d)", n))
+}
+
+func (q *Query) First() *Query {
+	return q.add("first()")
+}
+
+func (q *Query) Last() *Query {
+	return q.add("last()")
+}
+
+// Keep drops every column but the given ones
+func (q *Query) Keep(columns ...string) *Query {
+	return q.add(fmt.Sprintf("keep(columns: %s)", list(columns)))
+}
+
+// AggregateWindow aggregates the values in windows of the given duration with a built-in
+// aggregate such as mean or max, timed at the start of each window. fn is not escaped and must not come from user input.
+func (q *Query) AggregateWindow(every time.Duration, fn string, createEmpty bool) *Query {
+	return q.add(fmt.Sprintf(`aggregateWindow(every: %s, fn: %s, createEmpty: %t, timeSrc: "_start")`, Duration(every), fn, createEmpty))
+}
+
+func (q *Query) String() string {
+	return strings.Join(q.steps, "\n\t|> ")
+}
+
+func (q *Query) add(step string) *Query {
+	q.steps = append(q.steps, step)
+	return q
+}
+
+func list(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, String(value))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
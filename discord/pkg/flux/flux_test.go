@@ -0,0 +1,165 @@
+package flux
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var hostile = []string{
+	`plain`,
+	`"`,
+	`a") or true or ("`,
+	`\`,
+	`\"`,
+	`back\\slash\`,
+	`${`,
+	`${r._value}`,
+	`\${`,
+	`$`,
+	"new\nline",
+	"carriage\rreturn",
+	"tab\tbed",
+	"invalid \xff\xfe utf-8",
+	"\xc3",
+	`"] |> drop(columns: ["_value`,
+	"",
+}
+
+// parseLiteral decodes the Flux string literal at the start of s and returns its value and length.
+// It follows the string rules of the Flux spec, as implemented by ParseText in flux/internal/parser:
+// the escapes are \n \r \t \\ \" and \$, and an unescaped ${ starts an interpolation.
+func parseLiteral(s string) (string, int, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", 0, fmt.Errorf("no opening quote in %q", s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); {
+		r, width := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && width == 1 {
+			return "", 0, fmt.Errorf("invalid UTF-8 at %d", i)
+		}
+		switch {
+		case r == '"':
+			return b.String(), i + 1, nil
+		case r == '$' && strings.HasPrefix(s[i:], "${"):
+			return "", 0, fmt.Errorf("interpolation at %d", i)
+		case r == '\\':
+			if i+1 >= len(s) {
+				return "", 0, fmt.Errorf("dangling escape")
+			}
+			escaped, ok := map[byte]byte{'n': '\n', 'r': '\r', 't': '\t', '\\': '\\', '"': '"', '$': '$'}[s[i+1]]
+			if !ok {
+				return "", 0, fmt.Errorf("invalid escape %q", s[i:i+2])
+			}
+			b.WriteByte(escaped)
+			i += 2
+			continue
+		case r == '\n' || r == '\r':
+			return "", 0, fmt.Errorf("raw line break at %d", i)
+		}
+		b.WriteRune(r)
+		i += width
+	}
+	return "", 0, fmt.Errorf("no closing quote")
+}
+
+// splitLiterals replaces every string literal of the query with "" and returns the literal values in order,
+// so the structure of a query can be compared regardless of the values in it
+func splitLiterals(t *testing.T, query string) (string, []string) {
+	t.Helper()
+	var skeleton strings.Builder
+	var values []string
+	for i := 0; i < len(query); {
+		if query[i] != '"' {
+			skeleton.WriteByte(query[i])
+			i++
+			continue
+		}
+		value, n, err := parseLiteral(query[i:])
+		if err != nil {
+			t.Fatalf("invalid literal in %q: %v", query, err)
+		}
+		skeleton.WriteString(`""`)
+		values = append(values, value)
+		i += n
+	}
+	return skeleton.String(), values
+}
+
+func TestString(t *testing.T) {
+	for _, value := range hostile {
+		literal := String(value)
+		got, n, err := parseLiteral(literal)
+		if err != nil {
+			t.Errorf("String(%q) = %s does not parse: %v", value, literal, err)
+			continue
+		}
+		if n != len(literal) {
+			t.Errorf("String(%q) = %s ends early at %d", value, literal, n)
+		}
+		if want := strings.ToValidUTF8(value, "�"); got != want {
+			t.Errorf("String(%q) = %s decodes to %q, want %q", value, literal, got, want)
+		}
+	}
+}
+
+func TestBuildersKeepValuesInLiterals(t *testing.T) {
+	builders := map[string]func(value string) (string, []string){
+		"Eq": func(value string) (string, []string) {
+			return string(Eq(value, value)), []string{value, value}
+		},
+		"Ne": func(value string) (string, []string) {
+			return string(Ne(value, value)), []string{value, value}
+		},
+		"Exists": func(value string) (string, []string) {
+			return string(Exists(value)), []string{value}
+		},
+		"In": func(value string) (string, []string) {
+			return string(In("tag", value, "other", value)), []string{"tag", value, "tag", "other", "tag", value}
+		},
+		"From": func(value string) (string, []string) {
+			return From(value).String(), []string{value}
+		},
+		"Group": func(value string) (string, []string) {
+			return From("bucket").Group(value, "other").String(), []string{"bucket", value, "other"}
+		},
+		"Sort": func(value string) (string, []string) {
+			return From("bucket").Sort(true, value).String(), []string{"bucket", value}
+		},
+		"Keep": func(value string) (string, []string) {
+			return From("bucket").Keep(value).String(), []string{"bucket", value}
+		},
+		"Filter": func(value string) (string, []string) {
+			return From("bucket").Filter(And(Eq("tag", value), Not(Eq(value, "x")))).String(),
+				[]string{"bucket", "tag", value, value, "x"}
+		},
+	}
+
+	for name, build := range builders {
+		// Benign values give the expected structure, hostile ones must not change it
+		wantSkeleton, _ := splitLiterals(t, mustBuild(build, "x"))
+		for _, value := range hostile {
+			query, want := build(value)
+			skeleton, values := splitLiterals(t, query)
+			if skeleton != wantSkeleton {
+				t.Errorf("%s(%q) changed the query structure:\n%s\nwant\n%s", name, value, skeleton, wantSkeleton)
+			}
+			if len(values) != len(want) {
+				t.Errorf("%s(%q) has literals %q, want %q", name, value, values, want)
+				continue
+			}
+			for i := range want {
+				if values[i] != strings.ToValidUTF8(want[i], "�") {
+					t.Errorf("%s(%q) literal %d is %q, want %q", name, value, i, values[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+func mustBuild(build func(string) (string, []string), value string) string {
+	query, _ := build(value)
+	return query
+}
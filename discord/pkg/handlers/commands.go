@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/identity"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+// Commands are the application commands the bot registers, they are answered by InteractionCreate
+var Commands = []*discordgo.ApplicationCommand{
+	statusCommand,
+	voicestatsCommand,
+	leaderboardCommand,
+	lastseenCommand,
+	historyCommand,
+	linkCommand,
+}
+
+// Stats commands need a guild, only the link command can be used in DMs
+var dmPermission, guildOnly = true, false
+
+const embedColor = 0x5865F2
+
+// commandError is an error the user can fix, its message is shown to them
+type commandError string
+
+func (e commandError) Error() string {
+	return string(e)
+}
+
+// InteractionCreate answers the application commands. Answers query InfluxDB, so they run on their own goroutine.
+func (h *Handler) InteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	switch i.ApplicationCommandData().Name {
+	case statusCommand.Name:
+		go answerWithEmbed(s, i, h.statusEmbed)
+	case voicestatsCommand.Name:
+		go answerWithEmbed(s, i, h.voicestatsEmbed)
+	case leaderboardCommand.Name:
+		go answerWithEmbed(s, i, h.leaderboardEmbed)
+	case lastseenCommand.Name:
+		go answerWithEmbed(s, i, h.lastseenEmbed)
+	case historyCommand.Name:
+		go answerWithEmbed(s, i, h.historyEmbed)
+	case linkCommand.Name:
+		go h.linkInteraction(s, i)
+	}
+}
+
+// respond answers an interaction with a message only the user who used the command can see when ephemeral
+func respond(s *discordgo.Session, i *discordgo.InteractionCreate, content string, ephemeral bool) {
+	var flags discordgo.MessageFlags
+	if ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   flags,
+		},
+	})
+	if err != nil {
+		log.Println("error responding to interaction:", err)
+	}
+}
+
+// answerWithEmbed acknowledges the command right away, as Discord only waits 3 seconds for an answer,
+// then edits the answer with the embed or the error of build
+func answerWithEmbed(s *discordgo.Session, i *discordgo.InteractionCreate, build func(*discordgo.InteractionCreate) (*discordgo.MessageEmbed, error)) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Println("error acknowledging interaction:", err)
+		return
+	}
+
+	edit := &discordgo.WebhookEdit{}
+	embed, err := build(i)
+	var userErr commandError
+	switch {
+	case errors.As(err, &userErr):
+		content := "⚠️ " + userErr.Error()
+		edit.Content = &content
+	case err != nil:
+		log.Printf("error answering /%s: %v", i.ApplicationCommandData().Name, err)
+		content := "Something went wrong, try again later."
+		edit.Content = &content
+	default:
+		edit.Embeds = &[]*discordgo.MessageEmbed{embed}
+	}
+
+	_, err = s.InteractionResponseEdit(i.Interaction, edit)
+	if err != nil {
+		log.Println("error editing interaction response:", err)
+	}
+}
+
+// interactionUser returns the user of a command used in a guild or in a DM
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+func (h *Handler) stats(i *discordgo.InteractionCreate) *stats.Stats {
+	return stats.NewStats(h.Metrics, i.GuildID)
+}
+
+func options(i *discordgo.InteractionCreate) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	byName := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, option := range i.ApplicationCommandData().Options {
+		byName[option.Name] = option
+	}
+	return byName
+}
+
+var periodOption = &discordgo.ApplicationCommandOption{
+	Type:        discordgo.ApplicationCommandOptionString,
+	Name:        "period",
+	Description: "today, yesterday, week, month, year, all, 24h, 7d, 4w or <from> <to> as YYYY-MM-DD, this year by default",
+}
+
+// optionPeriod parses the period option, no period means the current year
+func optionPeriod(i *discordgo.InteractionCreate, st *stats.Stats) (period.Range, error) {
+	var args []string
+	if option, ok := options(i)["period"]; ok {
+		args = strings.Fields(option.StringValue())
+	}
+	r, err := period.Parse(args, time.Now(), st.Location)
+	if err != nil {
+		return period.Range{}, commandError(err.Error())
+	}
+	return r, nil
+}
+
+// optionUser resolves the user option to the user the stats know, users without stats are labeled with their Discord name
+func optionUser(i *discordgo.InteractionCreate, st *stats.Stats) (identity.User, error) {
+	userID := options(i)["user"].UserValue(nil).ID
+	user, err := st.ResolveUser(userID)
+	var notFound *identity.NotFoundError
+	if errors.As(err, &notFound) {
+		user = identity.User{UserID: userID, Username: userID}
+		if resolved, ok := i.ApplicationCommandData().Resolved.Users[userID]; ok {
+			user.Username = resolved.Username
+			user.DisplayName = resolved.GlobalName
+		}
+		return user, nil
+	}
+	return user, err
+}
+
+// truncate keeps the text within an embed limit
+func truncate(text string, limit int) string {
+	if len([]rune(text)) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit-1]) + "…"
+}
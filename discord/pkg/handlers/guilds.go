@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func (h *Handler) GuildCreate(s *discordgo.Session, gc *discordgo.GuildCreate) {
+	go func() {
+		err := h.Metrics.LogGuildRoles(gc.ID, gc.Roles)
+		if err != nil {
+			log.Println("error logging guild roles:", err)
+		}
+	}()
+}
+
+func (h *Handler) GuildRoleCreate(s *discordgo.Session, grc *discordgo.GuildRoleCreate) {
+	go func() {
+		err := h.Metrics.LogGuildRoles(grc.GuildID, []*discordgo.Role{grc.Role})
+		if err != nil {
+			log.Println("error logging guild role:", err)
+		}
+	}()
+}
+
+func (h *Handler) GuildRoleUpdate(s *discordgo.Session, gru *discordgo.GuildRoleUpdate) {
+	go func() {
+		err := h.Metrics.LogGuildRoles(gru.GuildID, []*discordgo.Role{gru.Role})
+		if err != nil {
+			log.Println("error logging guild role:", err)
+		}
+	}()
+}
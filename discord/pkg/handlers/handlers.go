@@ -2,192 +2,112 @@ package handlers
 
 import (
 	"log"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/pipeline"
+	"github.com/vcaldo/cerverox9/discord/pkg/tracker"
 )
 
-func VoiceStateUpdate(s *discordgo.Session, vsu *discordgo.VoiceStateUpdate) {
-	dm := models.NewAuthenticatedDiscordMetricsClient()
-
-	switch {
-	// User joined a voice channel
-	case vsu.BeforeUpdate == nil && vsu.ChannelID != "":
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has joined voice channel %s", user.Username, vsu.ChannelID)
+// Handler expects the session to dispatch events synchronously, so handlers see events in the order
+// Discord delivered them. Anything slow is submitted to the pipeline or run on its own goroutine.
+type Handler struct {
+	Metrics  *models.DiscordMetrics
+	Tracker  *tracker.Tracker
+	Pipeline *pipeline.Pipeline
+}
 
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.VoiceEvent, true)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+func NewHandler(metrics *models.DiscordMetrics, t *tracker.Tracker, p *pipeline.Pipeline) *Handler {
+	return &Handler{
+		Metrics:  metrics,
+		Tracker:  t,
+		Pipeline: p,
+	}
+}
 
-		// Update the number of users in voice channels when a user joins
-		err = dm.LogUsersPresence(s)
-		if err != nil {
-			log.Println("error register users in voice channels:", err)
-		}
-	// User left a voice channel
-	case vsu.ChannelID == "":
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has left voice channel %s", user.Username, vsu.BeforeUpdate.ChannelID)
+func (h *Handler) VoiceStateUpdate(s *discordgo.Session, vsu *discordgo.VoiceStateUpdate) {
+	// Events are stamped when they are received from the gateway, not when they are written
+	receivedAt := time.Now()
 
-		err = dm.LogVoiceEvent(s, vsu, vsu.BeforeUpdate.ChannelID, models.VoiceEvent, false)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	// The tracker knows the previous state of the user and the names of users and channels,
+	// the user counts are logged by the tracker changes so nothing is fetched from the REST API
+	before := h.Tracker.UpdateVoiceState(vsu, receivedAt)
 
-		// Update the number of users in voice channels when a user joins
-		err = dm.LogUsersPresence(s)
-		if err != nil {
-			log.Println("error register users in voice channels:", err)
-		}
+	switch {
+	// User joined a voice channel
+	case before.ChannelID == "" && vsu.ChannelID != "":
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.VoiceEvent, true, "has joined voice channel")
+		h.logMediaOn(vsu, receivedAt)
+	// User left a voice channel
+	case before.ChannelID != "" && vsu.ChannelID == "":
+		h.logVoiceEvent(vsu, receivedAt, before.ChannelID, models.VoiceEvent, false, "has left voice channel")
 	// User switched voice channels
-	case vsu.ChannelID != "" && vsu.BeforeUpdate.ChannelID != vsu.ChannelID:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has switched from voice channel %s to %s", user.Username, vsu.BeforeUpdate.ChannelID, vsu.ChannelID)
-
+	case before.ChannelID != vsu.ChannelID:
 		// When user swtiches channels, they leave the previous one and join the new one
-		err = dm.LogVoiceEvent(s, vsu, vsu.BeforeUpdate.ChannelID, models.VoiceEvent, false)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.VoiceEvent, true)
-		if err != nil {
-			log.Println("error register users in voice channels:", err)
-		}
-
-		// Update the number of users swtich channels
-		err = dm.LogUsersPresence(s)
-		if err != nil {
-			log.Println("error register users in voice channels:", err)
-		}
+		h.logVoiceEvent(vsu, receivedAt, before.ChannelID, models.VoiceEvent, false, "has switched from voice channel")
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.VoiceEvent, true, "has switched to voice channel")
+		h.logMediaOn(vsu, receivedAt)
 	// User started streaming
-	case !vsu.BeforeUpdate.SelfStream && vsu.SelfStream:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has started streaming in voice channel %s", user.Username, vsu.ChannelID)
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.StreamEvent, true)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	case !before.SelfStream && vsu.SelfStream:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.StreamEvent, true, "has started streaming in voice channel")
 	// User stopped streaming
-	case vsu.BeforeUpdate.SelfStream && !vsu.SelfStream:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has stopped streaming in voice channel %s", user.Username, vsu.ChannelID)
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.StreamEvent, false)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	case before.SelfStream && !vsu.SelfStream:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.StreamEvent, false, "has stopped streaming in voice channel")
 	// User turned their webcam on
-	case !vsu.BeforeUpdate.SelfVideo && vsu.SelfVideo:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has turned on their webcam in voice channel %s", user.Username, vsu.ChannelID)
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.WebcamEvent, true)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	case !before.SelfVideo && vsu.SelfVideo:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.WebcamEvent, true, "has turned on their webcam in voice channel")
 	// User turned their webcam off
-	case vsu.BeforeUpdate.SelfVideo && !vsu.SelfVideo:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has turned off their webcam in voice channel %s", user.Username, vsu.ChannelID)
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.WebcamEvent, false)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	case before.SelfVideo && !vsu.SelfVideo:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.WebcamEvent, false, "has turned off their webcam in voice channel")
 	// User muted themselves
-	case !vsu.BeforeUpdate.SelfMute && vsu.SelfMute:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has muted themselves in voice channel %s", user.Username, vsu.ChannelID)
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.MuteEvent, true)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	case !before.SelfMute && vsu.SelfMute:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.MuteEvent, true, "has muted themselves in voice channel")
 	// User unmuted themselves
-	case vsu.BeforeUpdate.SelfMute && !vsu.SelfMute:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has unmuted themselves in voice channel %s", user.Username, vsu.ChannelID)
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.MuteEvent, false)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	case before.SelfMute && !vsu.SelfMute:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.MuteEvent, false, "has unmuted themselves in voice channel")
 	// User deafened themselves
-	case !vsu.BeforeUpdate.SelfDeaf && vsu.SelfDeaf:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
-		}
-		log.Printf("User %s has deafened themselves in voice channel %s", user.Username, vsu.ChannelID)
-
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.DeafenEvent, true)
-		if err != nil {
-			log.Println("error logging voice event:", err)
-			return
-		}
+	case !before.SelfDeaf && vsu.SelfDeaf:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.DeafenEvent, true, "has deafened themselves in voice channel")
 	// User undeafened themselves
-	case vsu.BeforeUpdate.SelfDeaf && !vsu.SelfDeaf:
-		user, err := s.User(vsu.UserID)
-		if err != nil {
-			log.Println("error fetching user:", err)
-			return
+	case before.SelfDeaf && !vsu.SelfDeaf:
+		h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, models.DeafenEvent, false, "has undeafened themselves in voice channel")
+	}
+}
+
+// logMediaOn logs the media the user has on in the channel they joined or switched to. The leave of a switch
+// closes their media sessions, and the flags don't change with the join so no other case logs them.
+func (h *Handler) logMediaOn(vsu *discordgo.VoiceStateUpdate, receivedAt time.Time) {
+	media := []struct {
+		on        bool
+		eventType string
+		action    string
+	}{
+		{vsu.SelfStream, models.StreamEvent, "is streaming in voice channel"},
+		{vsu.SelfVideo, models.WebcamEvent, "has their webcam on in voice channel"},
+		{vsu.SelfMute, models.MuteEvent, "is muted in voice channel"},
+		{vsu.SelfDeaf, models.DeafenEvent, "is deafened in voice channel"},
+	}
+	for _, m := range media {
+		if m.on {
+			h.logVoiceEvent(vsu, receivedAt, vsu.ChannelID, m.eventType, true, m.action)
 		}
-		log.Printf("User %s has undeafened themselves in voice channel %s", user.Username, vsu.ChannelID)
+	}
+}
+
+// logVoiceEvent queues the event behind the previous events of the same user so they are written in order
+func (h *Handler) logVoiceEvent(vsu *discordgo.VoiceStateUpdate, receivedAt time.Time, channelID, eventType string, state bool, action string) {
+	subject := h.Tracker.Subject(vsu.GuildID, vsu.UserID, channelID)
+	log.Printf("User %s %s %s", subject.Username, action, subject.ChannelName)
 
-		err = dm.LogVoiceEvent(s, vsu, vsu.ChannelID, models.DeafenEvent, false)
+	queued := h.Pipeline.Submit(vsu.UserID, func() {
+		err := h.Metrics.LogVoiceEvent(vsu.GuildID, subject, eventType, state, receivedAt)
 		if err != nil {
 			log.Println("error logging voice event:", err)
-			return
 		}
+	})
+	// Blocking would stall the gateway, sessions missing this event are cut at the next one or at the max session
+	if !queued {
+		log.Printf("Pipeline queue full, dropping %s event of user %s", eventType, subject.Username)
 	}
 }
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+var historyCommand = &discordgo.ApplicationCommand{
+	Name:         "history",
+	Description:  "The latest voice sessions of a member",
+	DMPermission: &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: "The member to show the sessions of",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "n",
+			Description: "How many sessions to show, 10 by default",
+			MinValue:    &historyMinSize,
+			MaxValue:    25,
+		},
+	},
+}
+
+var historyMinSize = 1.0
+
+func (h *Handler) historyEmbed(i *discordgo.InteractionCreate) (*discordgo.MessageEmbed, error) {
+	st := h.stats(i)
+	user, err := optionUser(i, st)
+	if err != nil {
+		return nil, err
+	}
+	n := 10
+	if option, ok := options(i)["n"]; ok {
+		n = int(option.IntValue())
+	}
+
+	entries, err := st.GetUserHistory(user.UserID, n)
+	if err != nil {
+		return nil, err
+	}
+
+	var description strings.Builder
+	for _, entry := range entries {
+		ongoing := ""
+		if entry.Ongoing {
+			ongoing = " 🟢"
+		}
+		description.WriteString(fmt.Sprintf("<t:%d:f> · **%s** · %s%s%s\n",
+			entry.Start.Unix(), entry.ChannelName, stats.FormatDuration(entry.Duration()), stats.HistoryIcons(entry), ongoing))
+	}
+	if len(entries) == 0 {
+		description.WriteString("Never seen in a voice channel")
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("🕰️ Latest sessions of %s", user.Label()),
+		Description: truncate(description.String(), 4096),
+		Color:       embedColor,
+	}, nil
+}
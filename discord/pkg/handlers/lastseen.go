@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+var lastseenCommand = &discordgo.ApplicationCommand{
+	Name:         "lastseen",
+	Description:  "When a member was last in a voice channel and for how long",
+	DMPermission: &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: "The member to look for",
+			Required:    true,
+		},
+	},
+}
+
+func (h *Handler) lastseenEmbed(i *discordgo.InteractionCreate) (*discordgo.MessageEmbed, error) {
+	st := h.stats(i)
+	user, err := optionUser(i, st)
+	if err != nil {
+		return nil, err
+	}
+
+	session, ok, err := st.GetLastSession(user.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Discord shows the timestamps in the time zone of whoever reads them
+	description := "Never seen in a voice channel"
+	switch {
+	case ok && session.Ongoing:
+		description = fmt.Sprintf("🟢 On call in **%s** since <t:%d:t>, for %s", session.ChannelName, session.Start.Unix(), stats.FormatDuration(session.Duration()))
+	case ok:
+		description = fmt.Sprintf("Last seen in **%s** <t:%d:R>, on <t:%d:f> for %s", session.ChannelName, session.End.Unix(), session.Start.Unix(), stats.FormatDuration(session.Duration()))
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("👀 %s", user.Label()),
+		Description: description,
+		Color:       embedColor,
+	}, nil
+}
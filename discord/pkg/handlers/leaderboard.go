@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+var leaderboardCommand = &discordgo.ApplicationCommand{
+	Name:         "leaderboard",
+	Description:  "Members ranked by voice, streaming or webcam time",
+	DMPermission: &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "type",
+			Description: "What to rank by, voice time by default",
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "Voice", Value: models.VoiceEvent},
+				{Name: "Streaming", Value: models.StreamEvent},
+				{Name: "Webcam", Value: models.WebcamEvent},
+			},
+		},
+		{
+			Type:         discordgo.ApplicationCommandOptionChannel,
+			Name:         "channel",
+			Description:  "Only count the time in this voice channel",
+			ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice},
+		},
+		periodOption,
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "n",
+			Description: "How many members to show, 10 by default",
+			MinValue:    &leaderboardMinSize,
+			MaxValue:    25,
+		},
+	},
+}
+
+var leaderboardMinSize = 1.0
+
+func (h *Handler) leaderboardEmbed(i *discordgo.InteractionCreate) (*discordgo.MessageEmbed, error) {
+	st := h.stats(i)
+	r, err := optionPeriod(i, st)
+	if err != nil {
+		return nil, err
+	}
+
+	eventType := models.VoiceEvent
+	var channelID, channelName string
+	n := 10
+	opts := options(i)
+	if option, ok := opts["type"]; ok {
+		eventType = option.StringValue()
+	}
+	if option, ok := opts["channel"]; ok {
+		channelID = option.ChannelValue(nil).ID
+		channelName = channelID
+		if channel, ok := i.ApplicationCommandData().Resolved.Channels[channelID]; ok {
+			channelName = channel.Name
+		}
+	}
+	if option, ok := opts["n"]; ok {
+		n = int(option.IntValue())
+	}
+
+	entries, err := st.GetLeaderboard(r, eventType, channelID, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       stats.LeaderboardTitle(eventType, channelName, r),
+		Description: truncate(stats.FormatLeaderboard(entries), 4096),
+		Color:       embedColor,
+	}, nil
+}
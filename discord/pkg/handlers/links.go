@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/models"
+)
+
+var linkCommand = &discordgo.ApplicationCommand{
+	Name:         "link",
+	Description:  "Link your Telegram account with the code /link gave you in Telegram",
+	DMPermission: &dmPermission,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "code",
+			Description: "The code the Telegram bot sent you",
+			Required:    true,
+		},
+	},
+}
+
+func (h *Handler) linkInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	code := i.ApplicationCommandData().Options[0].StringValue()
+	respond(s, i, h.linkAccount(code, interactionUser(i)), true)
+}
+
+// MessageCreate links accounts with the codes sent to the bot in a DM, as in "link ABC123" or just the code
+func (h *Handler) MessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID != "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+
+	words := strings.Fields(m.Content)
+	if len(words) == 2 && strings.EqualFold(strings.TrimPrefix(words[0], "/"), "link") {
+		words = words[1:]
+	}
+	if len(words) != 1 {
+		return
+	}
+
+	go func() {
+		_, err := s.ChannelMessageSend(m.ChannelID, h.linkAccount(words[0], m.Author))
+		if err != nil {
+			log.Println("error sending link confirmation:", err)
+		}
+	}()
+}
+
+// linkAccount confirms a link code for the Discord user and returns the answer to send them
+func (h *Handler) linkAccount(code string, user *discordgo.User) string {
+	account, err := h.Metrics.ConfirmLinkCode(code, user.ID)
+	if errors.Is(err, models.ErrInvalidLinkCode) {
+		return "That code is invalid, expired or was already used. Send /link to the Telegram bot to get a new one."
+	}
+	if errors.Is(err, models.ErrTooManyLinkAttempts) {
+		return "Too many invalid codes, wait a few minutes before trying again."
+	}
+	if err != nil {
+		log.Println("error confirming link code:", err)
+		return "Something went wrong linking your account, try again later."
+	}
+
+	log.Printf("Linked Discord user %s to Telegram user %d", user.Username, account.UserID)
+	telegramName := fmt.Sprint(account.UserID)
+	if account.Username != "" {
+		telegramName = "@" + account.Username
+	}
+	return fmt.Sprintf("✅ Your Discord account is now linked to Telegram user %s.", telegramName)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+var statusCommand = &discordgo.ApplicationCommand{
+	Name:         "status",
+	Description:  "Who is on call and who is online",
+	DMPermission: &guildOnly,
+}
+
+func (h *Handler) statusEmbed(i *discordgo.InteractionCreate) (*discordgo.MessageEmbed, error) {
+	st := h.stats(i)
+	status, err := st.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	description := fmt.Sprintf("**%d** users having fun in the call, **%d** one click away", status.OncallCount, status.OnlineCount)
+	if len(status.Last24h) > 0 {
+		description += fmt.Sprintf("\n\nLast 24h: %s", analytics.Sparkline(status.Last24h))
+		if status.HasUsual {
+			description += "\n" + status.UsualComparison(now.In(st.Location))
+		}
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Live stats for %s", status.GuildName),
+		Description: description,
+		Color:       embedColor,
+		Timestamp:   now.Format(time.RFC3339),
+	}
+	for _, channel := range status.Channels {
+		members := make([]string, 0, len(channel.Members))
+		for _, member := range channel.Members {
+			members = append(members, stats.FormatChannelMember(member, now))
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  truncate(fmt.Sprintf("🔊 %s (%d)", channel.ChannelName, len(channel.Members)), 256),
+			Value: truncate(strings.Join(members, "\n"), 1024),
+		})
+	}
+	// The flat list is kept as a fallback when the channel members aren't logged
+	if len(status.Channels) == 0 {
+		oncall := strings.Join(status.Oncall, "\n")
+		if status.OncallCount == 0 {
+			oncall = "Empty Discord. Crowded streets."
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "🔊 On call",
+			Value: truncate(oncall, 1024),
+		})
+	}
+	if status.OnlineCount > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("🟢 Online (%d)", status.OnlineCount),
+			Value: truncate(strings.Join(status.Online, ", "), 1024),
+		})
+	}
+	return embed, nil
+}
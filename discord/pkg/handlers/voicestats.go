@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+var voicestatsCommand = &discordgo.ApplicationCommand{
+	Name:         "voicestats",
+	Description:  "Voice time of a member",
+	DMPermission: &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: "The member to show the voice time of",
+			Required:    true,
+		},
+		periodOption,
+	},
+}
+
+func (h *Handler) voicestatsEmbed(i *discordgo.InteractionCreate) (*discordgo.MessageEmbed, error) {
+	st := h.stats(i)
+	r, err := optionPeriod(i, st)
+	if err != nil {
+		return nil, err
+	}
+	user, err := optionUser(i, st)
+	if err != nil {
+		return nil, err
+	}
+
+	voiceTime, err := st.GetUserVoiceTime(user.UserID, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("📊 Total on-call hours for %s", user.Label()),
+		Description: fmt.Sprintf("**%s** %s", stats.FormatDuration(voiceTime), r.Label),
+		Color:       embedColor,
+	}, nil
+}
@@ -0,0 +1,189 @@
+// Package identity resolves what people type to refer to a Discord user, such as a username,
+// a nickname or a mention, to the user ID the stats are keyed on.
+package identity
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type User struct {
+	UserID      string
+	Username    string
+	DisplayName string
+	Names       []string // Every name the user was known by, the current ones included
+}
+
+// Label is the display name followed by the username when they differ
+func (u User) Label() string {
+	if u.DisplayName == "" || strings.EqualFold(u.DisplayName, u.Username) {
+		return u.Username
+	}
+	return fmt.Sprintf("%s (@%s)", u.DisplayName, u.Username)
+}
+
+// NotFoundError is returned when no user matches, with the closest names as suggestions
+type NotFoundError struct {
+	Query       string
+	Suggestions []User
+}
+
+func (e *NotFoundError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("no user matches %q", e.Query)
+	}
+	return fmt.Sprintf("no user matches %q, did you mean %s?", e.Query, Labels(e.Suggestions, " or "))
+}
+
+// AmbiguousError is returned when a name belongs to more than one user
+type AmbiguousError struct {
+	Query   string
+	Matches []User
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("%q matches %s, use their username or mention", e.Query, Labels(e.Matches, ", "))
+}
+
+var mention = regexp.MustCompile(`^<@!?(\d+)>$`)
+
+type Resolver struct {
+	users []User
+}
+
+func NewResolver(users []User) *Resolver {
+	return &Resolver{users: users}
+}
+
+// Resolve finds the user given by mention, ID, current or past name. Usernames are unique and win
+// over display names, exact names win over partial ones, and a partial name only resolves when a
+// single user has it.
+func (r *Resolver) Resolve(query string) (User, error) {
+	query = strings.TrimSpace(query)
+	if m := mention.FindStringSubmatch(query); m != nil {
+		query = m[1]
+	}
+	query = strings.TrimPrefix(query, "@")
+	if query == "" {
+		return User{}, &NotFoundError{Query: query}
+	}
+
+	for _, user := range r.users {
+		if user.UserID == query {
+			return user, nil
+		}
+	}
+
+	matchers := []func(User) bool{
+		func(u User) bool { return strings.EqualFold(u.Username, query) },
+		func(u User) bool { return strings.EqualFold(u.DisplayName, query) },
+		func(u User) bool {
+			return hasName(u, func(name string) bool { return strings.EqualFold(name, query) })
+		},
+		func(u User) bool {
+			lower := strings.ToLower(query)
+			return hasName(u, func(name string) bool { return strings.Contains(strings.ToLower(name), lower) })
+		},
+	}
+	for i, matches := range matchers {
+		var found []User
+		for _, user := range r.users {
+			if matches(user) {
+				found = append(found, user)
+			}
+		}
+		switch {
+		case len(found) == 1:
+			return found[0], nil
+		case len(found) > 1 && i < len(matchers)-1:
+			return User{}, &AmbiguousError{Query: query, Matches: found}
+		}
+	}
+
+	return User{}, &NotFoundError{Query: query, Suggestions: r.suggestions(query, 3)}
+}
+
+// suggestions returns up to n users with a name close to the query, closest first
+func (r *Resolver) suggestions(query string, n int) []User {
+	query = strings.ToLower(query)
+	maxDistance := len([]rune(query)) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	type scored struct {
+		user     User
+		distance int
+	}
+	var nearby []scored
+	for _, user := range r.users {
+		best := -1
+		for _, name := range user.Names {
+			name = strings.ToLower(name)
+			distance := levenshtein(query, name)
+			if strings.Contains(name, query) {
+				distance = 0
+			}
+			if best == -1 || distance < best {
+				best = distance
+			}
+		}
+		if best >= 0 && best <= maxDistance {
+			nearby = append(nearby, scored{user: user, distance: best})
+		}
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		if nearby[i].distance != nearby[j].distance {
+			return nearby[i].distance < nearby[j].distance
+		}
+		return nearby[i].user.Username < nearby[j].user.Username
+	})
+
+	suggestions := make([]User, 0, n)
+	for i := 0; i < len(nearby) && i < n; i++ {
+		suggestions = append(suggestions, nearby[i].user)
+	}
+	return suggestions
+}
+
+func hasName(u User, match func(string) bool) bool {
+	for _, name := range u.Names {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Labels joins the labels of the users with the separator
+func Labels(users []User, separator string) string {
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user.Label())
+	}
+	return strings.Join(names, separator)
+}
+
+// levenshtein is the edit distance between two strings, counted in runes
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
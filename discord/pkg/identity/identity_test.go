@@ -0,0 +1,50 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	resolver := NewResolver([]User{
+		{UserID: "1", Username: "alice", DisplayName: "Bob", Names: []string{"alice", "Bob"}},
+		{UserID: "2", Username: "bob", DisplayName: "Robert", Names: []string{"bob", "Robert", "bobby"}},
+		{UserID: "3", Username: "carol", DisplayName: "Sam", Names: []string{"carol", "Sam"}},
+		{UserID: "4", Username: "dave", DisplayName: "Sam", Names: []string{"dave", "Sam", "davey"}},
+	})
+
+	tests := []struct {
+		query     string
+		want      string
+		ambiguous bool
+		notFound  bool
+	}{
+		{query: "bob", want: "2"},       // Username wins over another user's display name
+		{query: "<@1>", want: "1"},      // Mention
+		{query: "@alice", want: "1"},    // Username with the @ prefix
+		{query: "robert", want: "2"},    // Display name
+		{query: "bobby", want: "2"},     // Past name
+		{query: "car", want: "3"},       // Partial name
+		{query: "Sam", ambiguous: true}, // Display name shared by two users
+		{query: "davey", want: "4"},
+		{query: "zed", notFound: true},
+	}
+
+	for _, tt := range tests {
+		user, err := resolver.Resolve(tt.query)
+		var ambiguous *AmbiguousError
+		var notFound *NotFoundError
+		switch {
+		case tt.ambiguous:
+			if !errors.As(err, &ambiguous) {
+				t.Errorf("Resolve(%q) = %v, %v, want an ambiguous error", tt.query, user.UserID, err)
+			}
+		case tt.notFound:
+			if !errors.As(err, &notFound) {
+				t.Errorf("Resolve(%q) = %v, %v, want a not found error", tt.query, user.UserID, err)
+			}
+		case err != nil || user.UserID != tt.want:
+			t.Errorf("Resolve(%q) = %v, %v, want %v", tt.query, user.UserID, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+const (
+	AchievementsMeasurement         = "achievements"
+	AchievementBackfillsMeasurement = "achievement_backfills"
+	AchievementKey                  = "achievement"
+	UnlockedKey                     = "unlocked"
+	BackfilledKey                   = "backfilled"
+)
+
+// GetAchievementUnlocks computes the achievements every member unlocked from the voice history of the guild in the range,
+// the achievements that count days or sessions only count the ones in the range
+func (dm *DiscordMetrics) GetAchievementUnlocks(guildID string, r period.Range, loc *time.Location) ([]analytics.Unlock, error) {
+	allSessions, err := dm.GetSessions(guildID, r, flux.In(EventTypeKey, VoiceEvent, StreamEvent))
+	if err != nil {
+		return nil, err
+	}
+
+	return analytics.Unlocks(
+		sessions.Filter(allSessions, VoiceEvent),
+		sessions.Filter(allSessions, StreamEvent),
+		r.Start,
+		loc,
+	), nil
+}
+
+// GetAchievements returns the stored achievements of the guild sorted by unlock time, only the ones of the user given by ID when it's not empty
+func (dm *DiscordMetrics) GetAchievements(guildID, userID string) ([]analytics.Unlock, error) {
+	predicates := []flux.Predicate{
+		flux.Eq("_measurement", AchievementsMeasurement),
+		flux.Eq(GuildIdKey, guildID),
+	}
+	if userID != "" {
+		predicates = append(predicates, flux.Eq(UserIdKey, userID))
+	}
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(predicates...)).
+		Pivot().
+		Group().
+		Sort(false, "_time")
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying for achievements: %v", err)
+	}
+	defer result.Close()
+
+	var unlocks []analytics.Unlock
+	seen := map[string]bool{}
+	for result.Next() {
+		values := result.Record().Values()
+		achievement, ok := analytics.AchievementByID(fmt.Sprint(values[AchievementKey]))
+		userID, _ := values[UserIdKey].(string)
+		if !ok || seen[userID+"/"+achievement.ID] {
+			continue
+		}
+		seen[userID+"/"+achievement.ID] = true
+		username, _ := values[UsernameKey].(string)
+		displayName, _ := values[UserDisplayNameKey].(string)
+		unlocks = append(unlocks, analytics.Unlock{
+			Achievement: achievement,
+			Person:      analytics.Person{UserID: userID, Username: username, DisplayName: displayName},
+			At:          result.Record().Time(),
+		})
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating achievements: %v", err)
+	}
+	sort.SliceStable(unlocks, func(i, j int) bool {
+		return unlocks[i].At.Before(unlocks[j].At)
+	})
+	return unlocks, nil
+}
+
+// LogAchievements stores the unlocked achievements at the time they were unlocked
+func (dm *DiscordMetrics) LogAchievements(guildID string, unlocks []analytics.Unlock) error {
+	points := make([]*write.Point, 0, len(unlocks))
+	for _, unlock := range unlocks {
+		points = append(points, influxdb2.NewPoint(AchievementsMeasurement,
+			map[string]string{
+				GuildIdKey:     guildID,
+				UserIdKey:      unlock.UserID,
+				AchievementKey: unlock.ID,
+			},
+			map[string]interface{}{
+				UnlockedKey:        true,
+				UsernameKey:        unlock.Username,
+				UserDisplayNameKey: unlock.DisplayName,
+			},
+			unlock.At))
+	}
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), points...)
+	if err != nil {
+		return fmt.Errorf("error logging achievements: %v", err)
+	}
+	return nil
+}
+
+// IsAchievementsBackfilled reports whether the achievements of the guild history were already stored
+func (dm *DiscordMetrics) IsAchievementsBackfilled(guildID string) (bool, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", AchievementBackfillsMeasurement),
+			flux.Eq(GuildIdKey, guildID),
+			flux.Eq("_field", BackfilledKey),
+		)).
+		Last()
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return false, fmt.Errorf("error querying for achievement backfills: %v", err)
+	}
+	defer result.Close()
+
+	backfilled := false
+	for result.Next() {
+		backfilled, _ = result.Record().Value().(bool)
+	}
+	if err := result.Err(); err != nil {
+		return false, fmt.Errorf("error iterating achievement backfills: %v", err)
+	}
+	return backfilled, nil
+}
+
+// LogAchievementsBackfill records that the achievements of the guild history were stored, so they aren't announced
+func (dm *DiscordMetrics) LogAchievementsBackfill(guildID string) error {
+	p := influxdb2.NewPoint(AchievementBackfillsMeasurement,
+		map[string]string{
+			GuildIdKey: guildID,
+		},
+		map[string]interface{}{
+			BackfilledKey: true,
+		},
+		time.Now())
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), p)
+	if err != nil {
+		return fmt.Errorf("error logging achievement backfill: %v", err)
+	}
+	return nil
+}
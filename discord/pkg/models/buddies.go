@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// GetCoPresence returns how long every pair of members spent in the same channel in the range
+func (dm *DiscordMetrics) GetCoPresence(guildID string, r period.Range) ([]analytics.Pair, error) {
+	voiceSessions, err := dm.GetSessions(guildID, r, flux.Eq(EventTypeKey, VoiceEvent))
+	if err != nil {
+		return nil, err
+	}
+
+	return analytics.CoPresence(sessions.Filter(voiceSessions, VoiceEvent)), nil
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// GetChannelStats returns the occupancy stats of the channel, matched by ID or name, in the range
+func (dm *DiscordMetrics) GetChannelStats(guildID, channel string, r period.Range, loc *time.Location) (analytics.ChannelStats, error) {
+	voiceSessions, err := dm.GetSessions(guildID, r, flux.Eq(EventTypeKey, VoiceEvent))
+	if err != nil {
+		return analytics.ChannelStats{}, err
+	}
+
+	channelSessions := analytics.InChannel(sessions.Filter(voiceSessions, VoiceEvent), channel)
+	if len(channelSessions) == 0 {
+		return analytics.ChannelStats{}, fmt.Errorf("no voice sessions found in channel %s", channel)
+	}
+	return analytics.Channel(channelSessions, loc, 5), nil
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+)
+
+const (
+	ChatSettingsMeasurement = "chat_settings"
+	ChatIdKey               = "chat_id"
+	TimezoneKey             = "timezone"
+)
+
+// SetChatTimezone sets the time zone the Telegram chat reads and writes times in
+func (dm *DiscordMetrics) SetChatTimezone(chatID int64, loc *time.Location) error {
+	p := influxdb2.NewPoint(ChatSettingsMeasurement,
+		map[string]string{
+			ChatIdKey: strconv.FormatInt(chatID, 10),
+		},
+		map[string]interface{}{
+			TimezoneKey: loc.String(),
+		},
+		time.Now())
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), p)
+	if err != nil {
+		return fmt.Errorf("error logging chat time zone: %v", err)
+	}
+	return nil
+}
+
+// GetChatTimezone returns the time zone set for the Telegram chat, false when it was never set
+func (dm *DiscordMetrics) GetChatTimezone(chatID int64) (*time.Location, bool, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", ChatSettingsMeasurement),
+			flux.Eq(ChatIdKey, strconv.FormatInt(chatID, 10)),
+			flux.Eq("_field", TimezoneKey),
+		)).
+		Last()
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, false, fmt.Errorf("error querying for chat time zone: %v", err)
+	}
+	defer result.Close()
+
+	var name string
+	for result.Next() {
+		name, _ = result.Record().Value().(string)
+	}
+	if err := result.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating chat settings: %v", err)
+	}
+	if name == "" {
+		return nil, false, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("error loading chat time zone: %v", err)
+	}
+	return loc, true, nil
+}
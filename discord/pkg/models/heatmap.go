@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// GetOncallHeatmap returns the average on call users of the guild for each hour of the week over the last weeks
+func (dm *DiscordMetrics) GetOncallHeatmap(guildID string, weeks int, loc *time.Location) (analytics.HourOfWeek, error) {
+	r := period.Range{Start: time.Now().AddDate(0, 0, -7*weeks)}
+	points, err := dm.GetUsersCountSeries(OncallUsersMeasurement, guildID, r, time.Hour, "mean")
+	if err != nil {
+		return analytics.HourOfWeek{}, err
+	}
+
+	return analytics.AverageByHourOfWeek(points, loc), nil
+}
+
+// GetUserHeatmap returns the share of each hour of the week the user, given by ID, spent on call over the last weeks
+func (dm *DiscordMetrics) GetUserHeatmap(guildID, userID string, weeks int, loc *time.Location) (analytics.HourOfWeek, error) {
+	r := period.Range{Start: time.Now().AddDate(0, 0, -7*weeks)}
+	userSessions, err := dm.GetSessions(guildID, r, flux.Eq(EventTypeKey, VoiceEvent), flux.Eq(UserIdKey, userID))
+	if err != nil {
+		return analytics.HourOfWeek{}, err
+	}
+
+	return analytics.PresenceByHourOfWeek(sessions.Filter(userSessions, VoiceEvent), weeks, loc), nil
+}
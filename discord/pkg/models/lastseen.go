@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// GetLastSession returns the latest voice session of the user, given by ID, which is ongoing when they're on call
+func (dm *DiscordMetrics) GetLastSession(guildID, userID string) (sessions.Session, bool, error) {
+	lastEvent, ok, err := dm.getLastVoiceEventTime(guildID, userID)
+	if err != nil || !ok {
+		return sessions.Session{}, false, err
+	}
+
+	// The session of the last event started at most a max session before it
+	userSessions, err := dm.GetSessions(guildID, period.Range{Start: lastEvent.Add(-dm.maxSession())},
+		flux.Eq(EventTypeKey, VoiceEvent),
+		flux.Eq(UserIdKey, userID),
+	)
+	if err != nil {
+		return sessions.Session{}, false, err
+	}
+
+	voiceSessions := sessions.Filter(userSessions, VoiceEvent)
+	if len(voiceSessions) == 0 {
+		return sessions.Session{}, false, nil
+	}
+	return voiceSessions[len(voiceSessions)-1], true, nil
+}
+
+func (dm *DiscordMetrics) getLastVoiceEventTime(guildID, userID string) (time.Time, bool, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", VoiceEventsMeasurement),
+			flux.Eq(GuildIdKey, guildID),
+			flux.Eq(UserIdKey, userID),
+			flux.Eq(EventTypeKey, VoiceEvent),
+			flux.Eq("_field", StateKey),
+		)).
+		Group().
+		Sort(true, "_time").
+		Limit(1)
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error querying for last voice event: %v", err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+		return result.Record().Time(), true, nil
+	}
+	if err := result.Err(); err != nil {
+		return time.Time{}, false, fmt.Errorf("error iterating voice events: %v", err)
+	}
+	return time.Time{}, false, nil
+}
+
+// GetUserHistory returns the latest n voice sessions of the user, given by ID, latest first.
+// The window grows from a month to all time until it holds enough sessions.
+func (dm *DiscordMetrics) GetUserHistory(guildID, userID string, n int) ([]analytics.HistoryEntry, error) {
+	now := time.Now()
+	windows := []period.Range{
+		{Start: now.AddDate(0, -1, 0)},
+		{Start: now.AddDate(-1, 0, 0)},
+		{},
+	}
+
+	var entries []analytics.HistoryEntry
+	for _, r := range windows {
+		userSessions, err := dm.GetSessions(guildID, r,
+			flux.In(EventTypeKey, VoiceEvent, StreamEvent, WebcamEvent),
+			flux.Eq(UserIdKey, userID),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = analytics.History(
+			sessions.Filter(userSessions, VoiceEvent),
+			sessions.Filter(userSessions, StreamEvent),
+			sessions.Filter(userSessions, WebcamEvent),
+			n,
+		)
+		if len(entries) >= n {
+			break
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// GetLeaderboard ranks the members of the guild by their time of the event type in the range, from a single query.
+// An empty channel ranks every channel, otherwise only the channel matched by ID or name.
+func (dm *DiscordMetrics) GetLeaderboard(guildID string, r period.Range, eventType, channel string, n int) ([]analytics.LeaderboardEntry, error) {
+	// Media sessions need the voice events too, leaving voice ends them
+	allSessions, err := dm.GetSessions(guildID, r, flux.In(EventTypeKey, VoiceEvent, eventType))
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := sessions.Filter(allSessions, eventType)
+	if channel != "" {
+		ranked = analytics.InChannel(ranked, channel)
+	}
+	return analytics.Leaderboard(ranked, n), nil
+}
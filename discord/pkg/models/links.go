@@ -0,0 +1,224 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+)
+
+const (
+	LinkCodesMeasurement    = "link_codes"
+	AccountLinksMeasurement = "account_links"
+	LinkCodeKey             = "code"
+	TelegramUserIdKey       = "telegram_user_id"
+	TelegramUsernameKey     = "telegram_username"
+	LinkedKey               = "linked"
+	LinkCodeTTL             = 10 * time.Minute
+	maxLinkFailures         = 5                                  // Invalid codes a Discord user can try in LinkCodeTTL
+	linkCodeAlphabet        = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // No 0/O or 1/I to misread
+	linkCodeLength          = 6
+)
+
+var (
+	ErrInvalidLinkCode     = errors.New("the code is invalid, expired or was already used")
+	ErrTooManyLinkAttempts = errors.New("too many invalid link codes, try again later")
+)
+
+type TelegramAccount struct {
+	UserID   int64
+	Username string
+}
+
+// CreateLinkCode returns a one time code the Telegram user confirms from Discord to link their accounts
+func (dm *DiscordMetrics) CreateLinkCode(account TelegramAccount) (string, error) {
+	var code strings.Builder
+	for i := 0; i < linkCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(linkCodeAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("error generating link code: %v", err)
+		}
+		code.WriteByte(linkCodeAlphabet[n.Int64()])
+	}
+
+	p := influxdb2.NewPoint(LinkCodesMeasurement,
+		map[string]string{
+			LinkCodeKey: code.String(),
+		},
+		map[string]interface{}{
+			TelegramUserIdKey:   account.UserID,
+			TelegramUsernameKey: account.Username,
+		},
+		time.Now())
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), p)
+	if err != nil {
+		return "", fmt.Errorf("error logging link code: %v", err)
+	}
+	return code.String(), nil
+}
+
+// ConfirmLinkCode links the Telegram account that created the code to the Discord user and uses the code up.
+// Codes are confirmed one at a time and deleted before linking, so a code can't link twice, and a Discord user
+// is locked out for a while after too many invalid codes.
+func (dm *DiscordMetrics) ConfirmLinkCode(code, discordUserID string) (TelegramAccount, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	dm.linksMu.Lock()
+	defer dm.linksMu.Unlock()
+
+	now := time.Now()
+	if dm.linkFailures == nil {
+		dm.linkFailures = map[string][]time.Time{}
+	}
+	var failures []time.Time
+	for _, at := range dm.linkFailures[discordUserID] {
+		if now.Sub(at) < LinkCodeTTL {
+			failures = append(failures, at)
+		}
+	}
+	dm.linkFailures[discordUserID] = failures
+	if len(failures) >= maxLinkFailures {
+		return TelegramAccount{}, ErrTooManyLinkAttempts
+	}
+
+	account, err := dm.getLinkCode(code)
+	if errors.Is(err, ErrInvalidLinkCode) {
+		dm.linkFailures[discordUserID] = append(failures, now)
+	}
+	if err != nil {
+		return TelegramAccount{}, err
+	}
+
+	predicate := fmt.Sprintf("_measurement=%s AND %s=%s",
+		deletePredicateString(LinkCodesMeasurement), LinkCodeKey, deletePredicateString(code))
+	err = dm.Client.DeleteAPI().DeleteWithName(context.Background(), dm.Org, dm.Bucket, now.Add(-2*LinkCodeTTL), now.Add(time.Minute), predicate)
+	if err != nil {
+		return TelegramAccount{}, fmt.Errorf("error deleting link code: %v", err)
+	}
+	delete(dm.linkFailures, discordUserID)
+
+	link := influxdb2.NewPoint(AccountLinksMeasurement,
+		map[string]string{
+			TelegramUserIdKey: strconv.FormatInt(account.UserID, 10),
+		},
+		map[string]interface{}{
+			UserIdKey:           discordUserID,
+			TelegramUsernameKey: account.Username,
+			LinkedKey:           true,
+		},
+		now)
+	err = dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), link)
+	if err != nil {
+		return TelegramAccount{}, fmt.Errorf("error logging account link: %v", err)
+	}
+	return account, nil
+}
+
+// getLinkCode returns the Telegram account that created the code, if it hasn't expired
+func (dm *DiscordMetrics) getLinkCode(code string) (TelegramAccount, error) {
+	query := flux.From(dm.Bucket).
+		RangeSince(LinkCodeTTL).
+		Filter(flux.And(
+			flux.Eq("_measurement", LinkCodesMeasurement),
+			flux.Eq(LinkCodeKey, code),
+		)).
+		Pivot().
+		Sort(true, "_time").
+		Limit(1)
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return TelegramAccount{}, fmt.Errorf("error querying for link code: %v", err)
+	}
+	defer result.Close()
+
+	var account TelegramAccount
+	for result.Next() {
+		values := result.Record().Values()
+		account.UserID, _ = values[TelegramUserIdKey].(int64)
+		account.Username, _ = values[TelegramUsernameKey].(string)
+	}
+	if err := result.Err(); err != nil {
+		return TelegramAccount{}, fmt.Errorf("error iterating link codes: %v", err)
+	}
+	if account.UserID == 0 {
+		return TelegramAccount{}, ErrInvalidLinkCode
+	}
+	return account, nil
+}
+
+// Unlink removes the link of the Telegram user, the link history is kept
+func (dm *DiscordMetrics) Unlink(telegramUserID int64) error {
+	p := influxdb2.NewPoint(AccountLinksMeasurement,
+		map[string]string{
+			TelegramUserIdKey: strconv.FormatInt(telegramUserID, 10),
+		},
+		map[string]interface{}{
+			UserIdKey: "",
+			LinkedKey: false,
+		},
+		time.Now())
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), p)
+	if err != nil {
+		return fmt.Errorf("error logging account unlink: %v", err)
+	}
+	return nil
+}
+
+// GetAccountLinks returns the Telegram account linked to each Discord user ID.
+// When several Telegram users linked the same Discord user the latest one wins.
+func (dm *DiscordMetrics) GetAccountLinks() (map[string]TelegramAccount, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.Eq("_measurement", AccountLinksMeasurement)).
+		Pivot().
+		Group(TelegramUserIdKey).
+		Sort(true, "_time").
+		Limit(1).
+		Group().
+		Sort(false, "_time")
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying for account links: %v", err)
+	}
+	defer result.Close()
+
+	links := map[string]TelegramAccount{}
+	for result.Next() {
+		values := result.Record().Values()
+		linked, _ := values[LinkedKey].(bool)
+		discordUserID, _ := values[UserIdKey].(string)
+		telegramUserID, err := strconv.ParseInt(fmt.Sprint(values[TelegramUserIdKey]), 10, 64)
+		if !linked || discordUserID == "" || err != nil {
+			continue
+		}
+		username, _ := values[TelegramUsernameKey].(string)
+		links[discordUserID] = TelegramAccount{UserID: telegramUserID, Username: username}
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating account links: %v", err)
+	}
+	return links, nil
+}
+
+// GetLinkedDiscordUser returns the Discord user ID linked to the Telegram user
+func (dm *DiscordMetrics) GetLinkedDiscordUser(telegramUserID int64) (string, bool, error) {
+	links, err := dm.GetAccountLinks()
+	if err != nil {
+		return "", false, err
+	}
+	for discordUserID, account := range links {
+		if account.UserID == telegramUserID {
+			return discordUserID, true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+)
+
+const (
+	SchemaMigrationsMeasurement = "schema_migrations"
+	SchemaVersionKey            = "version"
+	MigrationNameKey            = "name"
+	migrationBatchSize          = 5000
+)
+
+// Migration upgrades the stored data to a schema version. Migrations must be safe to run again
+// if they fail halfway, the version is only recorded once they succeed.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(dm *DiscordMetrics, dryRun bool) error
+}
+
+// Migrations are applied in order, version 1 is the schema the bot started with
+var Migrations = []Migration{
+	{Version: 2, Name: "move user_list tags to the members field", Up: migrateUserListTags},
+	{Version: 3, Name: "copy the names of voice events to user_names", Up: migrateVoiceEventNames},
+}
+
+// SchemaVersion returns the latest schema version recorded in the bucket, 1 if no migration ran yet
+func (dm *DiscordMetrics) SchemaVersion() (int, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", SchemaMigrationsMeasurement),
+			flux.Eq("_field", SchemaVersionKey),
+		))
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return 0, fmt.Errorf("error querying for schema version: %v", err)
+	}
+	defer result.Close()
+
+	version := 1
+	for result.Next() {
+		if v, ok := result.Record().Value().(int64); ok && int(v) > version {
+			version = int(v)
+		}
+	}
+	if err := result.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating schema versions: %v", err)
+	}
+	return version, nil
+}
+
+// Migrate applies the migrations newer than the stored schema version. A dry run only reports what would change.
+func (dm *DiscordMetrics) Migrate(dryRun bool) error {
+	version, err := dm.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	log.Printf("Schema version is %d", version)
+
+	for _, migration := range Migrations {
+		if migration.Version <= version {
+			continue
+		}
+		log.Printf("Applying migration %d: %s", migration.Version, migration.Name)
+		err := migration.Up(dm, dryRun)
+		if err != nil {
+			return fmt.Errorf("error applying migration %d: %v", migration.Version, err)
+		}
+		if dryRun {
+			continue
+		}
+
+		p := influxdb2.NewPoint(SchemaMigrationsMeasurement,
+			map[string]string{},
+			map[string]interface{}{
+				SchemaVersionKey: migration.Version,
+				MigrationNameKey: migration.Name,
+			},
+			time.Now())
+		err = dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), p)
+		if err != nil {
+			return fmt.Errorf("error recording migration %d: %v", migration.Version, err)
+		}
+		log.Printf("Schema version is now %d", migration.Version)
+	}
+	return nil
+}
+
+type userListSeries struct {
+	guildID  string
+	userList string
+}
+
+// migrateUserListTags rewrites the user count points of the v1 schema with the user list as the
+// members field, then deletes the series of every user_list tag value
+func migrateUserListTags(dm *DiscordMetrics, dryRun bool) error {
+	for _, measurement := range []string{OncallUsersMeasurement, OnlineUsersMeasurement} {
+		series, points, err := dm.rewriteUserListPoints(measurement, dryRun)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rewrote %d points of %d series in %s measurement", points, len(series), measurement)
+
+		if dryRun {
+			continue
+		}
+		for i, s := range series {
+			predicate := fmt.Sprintf(`_measurement=%s AND guild_id=%s AND user_list=%s`,
+				deletePredicateString(measurement), deletePredicateString(s.guildID), deletePredicateString(s.userList))
+			err := dm.Client.DeleteAPI().DeleteWithName(context.Background(), dm.Org, dm.Bucket, time.Unix(0, 0), time.Now(), predicate)
+			if err != nil {
+				return fmt.Errorf("error deleting %s series: %v", measurement, err)
+			}
+			if (i+1)%100 == 0 {
+				log.Printf("Deleted %d of %d series in %s measurement", i+1, len(series), measurement)
+			}
+		}
+		log.Printf("Deleted %d series in %s measurement", len(series), measurement)
+	}
+	return nil
+}
+
+// rewriteUserListPoints writes every point of the measurement that has a user_list tag in the v2
+// schema and returns the series they were read from. Rewritten points overwrite each other when run again.
+func (dm *DiscordMetrics) rewriteUserListPoints(measurement string, dryRun bool) ([]userListSeries, int, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", measurement),
+			flux.Eq("_field", UserCountKey),
+			flux.Exists(UserListKey),
+		))
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying for %s points: %v", measurement, err)
+	}
+	defer result.Close()
+
+	writeAPI := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket)
+	flush := func(batch []*write.Point) error {
+		if dryRun || len(batch) == 0 {
+			return nil
+		}
+		err := writeAPI.WritePoint(context.Background(), batch...)
+		if err != nil {
+			return fmt.Errorf("error writing %s points: %v", measurement, err)
+		}
+		return nil
+	}
+
+	seen := map[userListSeries]bool{}
+	batch := make([]*write.Point, 0, migrationBatchSize)
+	total := 0
+	for result.Next() {
+		record := result.Record()
+		values := record.Values()
+		guildID, _ := values[GuildIdKey].(string)
+		guildName, _ := values[GuildNameKey].(string)
+		userList, _ := values[UserListKey].(string)
+		seen[userListSeries{guildID: guildID, userList: userList}] = true
+
+		users, err := decodeUserList(values)
+		if err != nil {
+			return nil, 0, err
+		}
+		members, err := json.Marshal(users)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error encoding user list: %v", err)
+		}
+		batch = append(batch, influxdb2.NewPoint(measurement,
+			map[string]string{
+				GuildIdKey:   guildID,
+				GuildNameKey: guildName,
+			},
+			map[string]interface{}{
+				UserCountKey: record.Value(),
+				MembersKey:   string(members),
+			},
+			record.Time()))
+		total++
+
+		if len(batch) == migrationBatchSize {
+			err := flush(batch)
+			if err != nil {
+				return nil, 0, err
+			}
+			batch = batch[:0]
+			log.Printf("Rewrote %d points in %s measurement", total, measurement)
+		}
+	}
+	if err := result.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating %s points: %v", measurement, err)
+	}
+	err = flush(batch)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	series := make([]userListSeries, 0, len(seen))
+	for s := range seen {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].guildID != series[j].guildID {
+			return series[i].guildID < series[j].guildID
+		}
+		return series[i].userList < series[j].userList
+	})
+	return series, total, nil
+}
+
+// migrateVoiceEventNames writes every name a user had in their voice events to the name history, at the
+// last event with the name, so users are resolved from the name history alone. Points overwrite each other when run again.
+func migrateVoiceEventNames(dm *DiscordMetrics, dryRun bool) error {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", VoiceEventsMeasurement),
+			flux.Eq("_field", StateKey),
+			flux.Exists(UserIdKey),
+		)).
+		Keep("_time", "_value", GuildIdKey, UserIdKey, UsernameKey, UserDisplayNameKey).
+		Group(GuildIdKey, UserIdKey, UsernameKey, UserDisplayNameKey).
+		Last()
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return fmt.Errorf("error querying for voice event names: %v", err)
+	}
+	defer result.Close()
+
+	var points []*write.Point
+	for result.Next() {
+		record := result.Record()
+		values := record.Values()
+		userID, _ := values[UserIdKey].(string)
+		if userID == "" {
+			continue
+		}
+		guildID, _ := values[GuildIdKey].(string)
+		username, _ := values[UsernameKey].(string)
+		displayName, _ := values[UserDisplayNameKey].(string)
+		points = append(points, influxdb2.NewPoint(UserNamesMeasurement,
+			map[string]string{
+				GuildIdKey: guildID,
+				UserIdKey:  userID,
+			},
+			map[string]interface{}{
+				UsernameKey:        username,
+				UserDisplayNameKey: displayName,
+			},
+			record.Time()))
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("error iterating voice event names: %v", err)
+	}
+	log.Printf("Copying %d names of voice events to %s measurement", len(points), UserNamesMeasurement)
+	if dryRun {
+		return nil
+	}
+
+	writeAPI := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket)
+	for start := 0; start < len(points); start += migrationBatchSize {
+		batch := points[start:min(start+migrationBatchSize, len(points))]
+		err := writeAPI.WritePoint(context.Background(), batch...)
+		if err != nil {
+			return fmt.Errorf("error writing %s points: %v", UserNamesMeasurement, err)
+		}
+	}
+	return nil
+}
+
+// deletePredicateString quotes a value for a delete predicate, which isn't Flux and has its own escaping
+func deletePredicateString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
@@ -2,41 +2,62 @@ package models
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/bwmarrin/discordgo"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/vcaldo/cerverox9/discord/pkg/filters"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+	"github.com/vcaldo/cerverox9/discord/pkg/tracker"
 )
 
 const (
-	VoiceEventsMeasurement = "voice_events"
-	OncallUsersMeasurement = "oncall_users"
-	OnlineUsersMeasurement = "online_users"
-	UserIdKey              = "user_id"
-	UsernameKey            = "username"
-	UserDisplayNameKey     = "user_display_name"
-	GuildIdKey             = "guild_id"
-	ChannelIdKey           = "channel_id"
-	ChannelNameKey         = "channel_name"
-	EventTypeKey           = "event_type"
-	StateKey               = "state"
-	VoiceEvent             = "voice"
-	MuteEvent              = "mute"
-	DeafenEvent            = "deafen"
-	WebcamEvent            = "webcam"
-	StreamEvent            = "streaming"
+	VoiceEventsMeasurement      = "voice_events"
+	OncallUsersMeasurement      = "oncall_users"
+	OnlineUsersMeasurement      = "online_users"
+	ChannelOccupancyMeasurement = "channel_occupancy"
+	UserCountKey                = "user_count"
+	MembersKey                  = "members"
+	UserListKey                 = "user_list" // Comma joined tag of the v1 schema, replaced by the members field
+	UserIdKey                   = "user_id"
+	UsernameKey                 = "username"
+	UserDisplayNameKey          = "user_display_name"
+	GuildIdKey                  = "guild_id"
+	GuildNameKey                = "guild_name"
+	ChannelIdKey                = "channel_id"
+	ChannelNameKey              = "channel_name"
+	EventTypeKey                = "event_type"
+	StateKey                    = "state"
+	RoleIdsKey                  = "role_ids"
+	VoiceEvent                  = "voice"
+	MuteEvent                   = "mute"
+	DeafenEvent                 = "deafen"
+	WebcamEvent                 = "webcam"
+	StreamEvent                 = "streaming"
 )
 
 type DiscordMetrics struct {
-	Client influxdb2.Client
-	Org    string
-	Bucket string
-	Url    string
+	Client   influxdb2.Client
+	Org      string
+	Bucket   string
+	Url      string
+	Rules    *filters.Rules
+	Sessions sessions.Options
+
+	namesMu    sync.Mutex
+	knownNames map[string]string // Last written names by guild and user ID, loaded on first use
+
+	linksMu      sync.Mutex
+	linkFailures map[string][]time.Time // Failed link code attempts by Discord user ID
 }
 
 func NewAuthenticatedDiscordMetricsClient() *DiscordMetrics {
@@ -71,38 +92,25 @@ func newDiscordMetricsClient(url, token, org, bucket string) *DiscordMetrics {
 	}
 	client := influxdb2.NewClient(url, token)
 	return &DiscordMetrics{
-		Client: client,
-		Org:    org,
-		Bucket: bucket,
-		Url:    url,
+		Client:   client,
+		Org:      org,
+		Bucket:   bucket,
+		Url:      url,
+		Rules:    filters.NewRulesFromEnv(),
+		Sessions: sessions.OptionsFromEnv(),
 	}
 }
 
-func (dm *DiscordMetrics) LogVoiceEvent(s *discordgo.Session, vsu *discordgo.VoiceStateUpdate, channelID, voiceEvent string, state bool) error {
-	NewAuthenticatedDiscordMetricsClient()
-
-	// Ignore users in the ignore list
-	ignoredUsers := strings.Split(os.Getenv("DISCORD_IGNORED_USERNAMES"), ",")
-	if slices.Contains(ignoredUsers, vsu.Member.User.Username) {
+// LogVoiceEvent logs a voice event of the subject that happened at the given time unless it's matched by the filtering rules
+func (dm *DiscordMetrics) LogVoiceEvent(guildID string, subject filters.Subject, voiceEvent string, state bool, at time.Time) error {
+	if dm.Rules.Ignores(subject) {
 		return nil
 	}
 
-	user, err := s.User(vsu.UserID)
-	if err != nil {
-		return fmt.Errorf("error fetching user: %v", err)
-	}
-
-	channel, err := s.Channel(channelID)
-	if err != nil {
-		log.Println("error fetching channel:", err)
-		return fmt.Errorf("error fetching channel: %v", err)
-	}
-
-	dm.logVoiceEvent(vsu.UserID, user.Username, userDisplayName(vsu.Member), vsu.GuildID, channelID, channel.Name, voiceEvent, state)
-	return nil
+	return dm.logVoiceEvent(subject.UserID, subject.Username, subject.DisplayName, guildID, subject.ChannelID, subject.ChannelName, voiceEvent, state, subject.RoleIDs, at)
 }
 
-func (dm *DiscordMetrics) logVoiceEvent(userID, username, userDisplayName, guildID, channelID, channelName, eventType string, state bool) error {
+func (dm *DiscordMetrics) logVoiceEvent(userID, username, userDisplayName, guildID, channelID, channelName, eventType string, state bool, roleIDs []string, at time.Time) error {
 	writeAPI := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket)
 
 	p := influxdb2.NewPoint(VoiceEventsMeasurement,
@@ -116,93 +124,103 @@ func (dm *DiscordMetrics) logVoiceEvent(userID, username, userDisplayName, guild
 			EventTypeKey:       eventType,
 		},
 		map[string]interface{}{
-			StateKey: state,
+			StateKey:   state,
+			RoleIdsKey: strings.Join(roleIDs, ","), // Kept as a field to not add a series per role combination
 		},
-		time.Now())
+		at)
 	log.Printf("Writing point: %s, %s, %s, %t in %s measurement", username, userDisplayName, eventType, state, VoiceEventsMeasurement)
 
 	return writeAPI.WritePoint(context.Background(), p)
 }
 
-func (dm *DiscordMetrics) GetOncallUsers(guildID string) (guildName string, oncallUsersCount int64, oncallUsers string, error error) {
-	// query oncall users
-	query := fmt.Sprintf(`from(bucket:"%s")
-		|> range(start: -10m)
-		|> filter(fn: (r) => r._measurement == "%s" and r.guild_id == "%s")
-		|> group(columns: ["guild_id"])
-		|> sort(columns: ["_time"], desc: true)
-		|> limit(n: 1)
-		|> last()`,
-		dm.Bucket, OncallUsersMeasurement, guildID)
-
-	queryAPI := dm.Client.QueryAPI(dm.Org)
-	result, err := queryAPI.Query(context.Background(), query)
+// GetOncallUsers returns the users on call in the latest logged point of the guild
+func (dm *DiscordMetrics) GetOncallUsers(guildID string) (guildName string, oncallUsersCount int64, oncallUsers []string, error error) {
+	guildName, oncallUsersCount, oncallUsers, err := dm.getUsers(OncallUsersMeasurement, guildID)
 	if err != nil {
-		return "", 0, "", fmt.Errorf("error querying for oncall users: %v", err)
+		return "", 0, nil, fmt.Errorf("error querying for oncall users: %v", err)
 	}
-	defer result.Close()
+	return guildName, oncallUsersCount, oncallUsers, nil
+}
 
-	for result.Next() {
-		record := result.Record()
-		guildName := record.Values()["guild_name"].(string)
-		oncallUsersCount := record.Value().(int64)
-		var oncallUsers string
-		if oncallUsersCount == 0 {
-			oncallUsers = "Empty Discord. Crowded streets." // This can't have a comma
-			return guildName, oncallUsersCount, oncallUsers, nil
-		} else {
-			oncallUsers = record.Values()["user_list"].(string)
-			return guildName, oncallUsersCount, oncallUsers, nil
-		}
+// GetOnlineUsers returns the users online in the latest logged point of the guild
+func (dm *DiscordMetrics) GetOnlineUsers(guildID string) (guildName string, onlineUsersCount int64, onlineUsers []string, error error) {
+	guildName, onlineUsersCount, onlineUsers, err := dm.getUsers(OnlineUsersMeasurement, guildID)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("error querying for online users: %v", err)
 	}
-	return "", 0, "", fmt.Errorf("no online users found for guild %s", guildID)
+	return guildName, onlineUsersCount, onlineUsers, nil
 }
 
-func (dm *DiscordMetrics) GetOnlineUsers(guildID string) (guildName string, onlineUsersCount int64, onlineUsers string, error error) {
-	// query online users
-	query2 := fmt.Sprintf(`from(bucket:"%s")
-		|> range(start: -10m)
-		|> filter(fn: (r) => r._measurement == "%s" and r.guild_id == "%s")
-		|> group(columns: ["guild_id"])
-		|> sort(columns: ["_time"], desc: true)
-		|> limit(n: 1)
-		|> last()`,
-		dm.Bucket, OnlineUsersMeasurement, guildID)
+// getUsers reads the latest point of a user count measurement. Both the v1 schema, with the
+// comma joined user_list tag, and the v2 schema, with the JSON members field, are supported.
+func (dm *DiscordMetrics) getUsers(measurement, guildID string) (string, int64, []string, error) {
+	query := flux.From(dm.Bucket).
+		RangeSince(10*time.Minute).
+		Filter(flux.And(
+			flux.Eq("_measurement", measurement),
+			flux.Eq(GuildIdKey, guildID),
+		)).
+		Pivot().
+		Group(GuildIdKey).
+		Sort(true, "_time").
+		Limit(1)
 
 	queryAPI := dm.Client.QueryAPI(dm.Org)
-	result, err := queryAPI.Query(context.Background(), query2)
+	result, err := queryAPI.Query(context.Background(), query.String())
 	if err != nil {
-		return "", 0, "", fmt.Errorf("error querying for online users: %v", err)
+		return "", 0, nil, err
 	}
 	defer result.Close()
 
 	for result.Next() {
-		record := result.Record()
-		guildName := record.Values()["guild_name"].(string)
-		onlineUsersCount := record.Value().(int64)
-		var onlineUsers string
-		if onlineUsersCount == 0 {
-			onlineUsers = "" // This can't have a comma
-			return guildName, onlineUsersCount, onlineUsers, nil
-		} else {
-			onlineUsers = record.Values()["user_list"].(string)
-			return guildName, onlineUsersCount, onlineUsers, nil
+		values := result.Record().Values()
+		guildName, _ := values[GuildNameKey].(string)
+		userCount, _ := values[UserCountKey].(int64)
+		users, err := decodeUserList(values)
+		if err != nil {
+			return "", 0, nil, err
 		}
+		return guildName, userCount, users, nil
 	}
-	return "", 0, "", fmt.Errorf("no online users found for guild %s", guildID)
+	if err := result.Err(); err != nil {
+		return "", 0, nil, err
+	}
+	return "", 0, nil, fmt.Errorf("no %s found for guild %s", measurement, guildID)
+}
+
+// decodeUserList returns the users of a pivoted user count record in either schema
+func decodeUserList(values map[string]interface{}) ([]string, error) {
+	if members, ok := values[MembersKey].(string); ok {
+		users := []string{}
+		err := json.Unmarshal([]byte(members), &users)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding members: %v", err)
+		}
+		return users, nil
+	}
+	// v1 points: names with a comma were split when they were written, there's no way to tell them apart
+	if userList, ok := values[UserListKey].(string); ok && userList != "" {
+		return strings.Split(userList, ","), nil
+	}
+	return []string{}, nil
 }
 
 func (dm *DiscordMetrics) logUsersCount(measurementName, guildID, guildName string, userCount int, userList []string) error {
 	writeAPI := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket)
 
+	// The user list is a field, as a tag it would add a series per combination of users
+	members, err := json.Marshal(userList)
+	if err != nil {
+		return fmt.Errorf("error encoding user list: %v", err)
+	}
 	p := influxdb2.NewPoint(measurementName,
 		map[string]string{
-			"guild_id":   guildID,
-			"guild_name": guildName,
-			"user_list":  strings.Join(userList, ","),
+			GuildIdKey:   guildID,
+			GuildNameKey: guildName,
 		},
 		map[string]interface{}{
-			"user_count": userCount,
+			UserCountKey: userCount,
+			MembersKey:   string(members),
 		},
 		time.Now())
 	log.Printf("Writing point: %s, %d in %s measurement", guildID, userCount, measurementName)
@@ -210,120 +228,102 @@ func (dm *DiscordMetrics) logUsersCount(measurementName, guildID, guildName stri
 	return writeAPI.WritePoint(context.Background(), p)
 }
 
-func (dm *DiscordMetrics) LogUsersPresence(s *discordgo.Session) error {
-	guilds, err := s.UserGuilds(200, "", "", true)
-	if err != nil {
-		return fmt.Errorf("error fetching guilds: %v", err)
-	}
-	for _, guild := range guilds {
-		// Register oncall users
-		guildID := guild.ID
-		members, err := s.GuildMembers(guildID, "", 1000)
+// LogUsersPresence logs the on call and online users of every tracked guild.
+// A failing guild doesn't stop the others from being logged.
+func (dm *DiscordMetrics) LogUsersPresence(t *tracker.Tracker) error {
+	var errs []error
+	for _, guildID := range t.GuildIDs() {
+		err := dm.LogGuildPresence(t, guildID)
 		if err != nil {
-			log.Printf("error fetching members for guild %s: %v", guildID, err)
-			return fmt.Errorf("error fetching members for guild %s: %v", guildID, err)
-		}
-		oncallUsersCount := 0
-		oncallUsers := []string{}
-		for _, member := range members {
-			if member.User.Bot {
-				continue
-			}
-			vs, _ := s.State.VoiceState(guildID, member.User.ID) // it errors out if the user is not in a voice channel, ignore it
-			if vs != nil && vs.ChannelID != "" {
-				// Checj if the user is on an ignored channel
-				currentVoiceChannel, err := s.Channel(vs.ChannelID)
-				if err != nil {
-					log.Printf("error fetching channel for user %s: %v", member.User.ID, err)
-					continue
-				}
-				ignoredChannels := strings.Split(os.Getenv("DISCORD_IGNORED_CHANNELS"), ",")
-				if slices.Contains(ignoredChannels, currentVoiceChannel.Name) {
-					log.Printf("Ignoring user %s in ignored channel %s", member.User.ID, currentVoiceChannel.Name)
-					continue
-				}
-				oncallUsersCount++
-				oncallUsers = append(oncallUsers, userDisplayName(member))
-			}
+			log.Printf("error logging presence for guild %s: %v", guildID, err)
+			errs = append(errs, fmt.Errorf("guild %s: %v", guildID, err))
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		err = dm.logUsersCount(OncallUsersMeasurement, guildID, guild.Name, oncallUsersCount, oncallUsers)
-		if err != nil {
-			return fmt.Errorf("error logging online users: %v", err)
-		}
-		log.Printf("Logged %d on call users for guild %s - %s", oncallUsersCount, guildID, guild.Name)
-
-		// Register online users
-		onlineUsersCount := 0
-		onlineUsers := []string{}
-		for _, member := range members {
-			if member.User.Bot {
-				continue
-			}
-			presence, _ := s.State.Presence(guildID, member.User.ID) // it errors out if the user is not in a voice channel, ignore it
-			if presence != nil && presence.Status != discordgo.StatusOffline {
-				if !slices.Contains(oncallUsers, userDisplayName(member)) {
-					onlineUsersCount++
-					onlineUsers = append(onlineUsers, userDisplayName(member))
-				}
-			}
-		}
+// LogGuildPresence logs the on call and online users of a guild from the tracker snapshot
+func (dm *DiscordMetrics) LogGuildPresence(t *tracker.Tracker, guildID string) error {
+	snapshot, ok := t.Snapshot(guildID)
+	if !ok {
+		return fmt.Errorf("guild %s is not tracked", guildID)
+	}
+	if !t.MembersLoaded(guildID) {
+		log.Printf("Members of guild %s - %s are still loading", guildID, snapshot.GuildName)
+	}
 
-		err = dm.logUsersCount(OnlineUsersMeasurement, guildID, guild.Name, onlineUsersCount, onlineUsers)
-		if err != nil {
-			return fmt.Errorf("error logging online users: %v", err)
-		}
-		log.Printf("Logged %d online users for guild %s - %s", onlineUsersCount, guildID, guild.Name)
+	// Register oncall users
+	oncallUsers := make([]string, 0, len(snapshot.Oncall))
+	for _, member := range snapshot.Oncall {
+		oncallUsers = append(oncallUsers, member.DisplayName)
 	}
-	return nil
-}
+	err := dm.logUsersCount(OncallUsersMeasurement, guildID, snapshot.GuildName, len(oncallUsers), oncallUsers)
+	if err != nil {
+		return fmt.Errorf("error logging oncall users: %v", err)
+	}
+	log.Printf("Logged %d on call users for guild %s - %s", len(oncallUsers), guildID, snapshot.GuildName)
 
-func (dm *DiscordMetrics) GetUserVoiceTime(username, guildId, ignoredVoiceChannel string) (time.Duration, error) {
-	// Use existing client connection
-	queryAPI := dm.Client.QueryAPI(os.Getenv("INFLUX_ORG"))
-
-	query := fmt.Sprintf(`
-		from(bucket: "discord_metrics")
-			|> range(start: %d-01-01T00:00:00Z)
-			|> filter(fn: (r) =>
-				r["_measurement"] == "voice_events" and
-				r["event_type"] == "voice" and
-				r["username"] == "%s" and
-				r.guild_id == "%s" and
-				r["channel_id"] != "%s"
-			)
-			|> pivot(
-				rowKey: ["_time"],
-				columnKey: ["_field"],
-				valueColumn: "_value"
-			)
-			|> sort(columns: ["_time"])
-	`, time.Now().Year(), username, guildId, ignoredVoiceChannel)
-
-	result, err := queryAPI.Query(context.Background(), query)
+	// Register online users
+	onlineUsers := make([]string, 0, len(snapshot.Online))
+	for _, member := range snapshot.Online {
+		onlineUsers = append(onlineUsers, member.DisplayName)
+	}
+	err = dm.logUsersCount(OnlineUsersMeasurement, guildID, snapshot.GuildName, len(onlineUsers), onlineUsers)
 	if err != nil {
-		return 0, fmt.Errorf("query failed: %v", err)
+		return fmt.Errorf("error logging online users: %v", err)
 	}
+	log.Printf("Logged %d online users for guild %s - %s", len(onlineUsers), guildID, snapshot.GuildName)
 
-	var totalDuration time.Duration
-	var lastJoinTime time.Time
+	// Register users per voice channel, empty channels included so averages are right
+	err = dm.logChannelOccupancy(snapshot)
+	if err != nil {
+		return fmt.Errorf("error logging channel occupancy: %v", err)
+	}
+	return nil
+}
 
-	for result.Next() {
-		record := result.Record()
-		state, ok := record.ValueByKey("state").(bool)
-		if !ok {
-			continue
+func (dm *DiscordMetrics) logChannelOccupancy(snapshot tracker.Snapshot) error {
+	writeAPI := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket)
+
+	members := channelMembers(snapshot)
+
+	now := time.Now()
+	points := make([]*write.Point, 0, len(snapshot.Channels))
+	for _, channel := range snapshot.Channels {
+		occupants := members[channel.ID]
+		if occupants == nil {
+			occupants = []ChannelMember{}
 		}
-		timestamp := record.Time()
-
-		if state { // Join event
-			lastJoinTime = timestamp
-		} else if !lastJoinTime.IsZero() { // Leave event
-			duration := timestamp.Sub(lastJoinTime)
-			totalDuration += duration
-			lastJoinTime = time.Time{} // Reset
+		membersJSON, err := json.Marshal(occupants)
+		if err != nil {
+			return fmt.Errorf("error encoding members of channel %s: %v", channel.ID, err)
 		}
+		points = append(points, influxdb2.NewPoint(ChannelOccupancyMeasurement,
+			map[string]string{
+				GuildIdKey:     snapshot.GuildID,
+				ChannelIdKey:   channel.ID,
+				ChannelNameKey: channel.Name,
+			},
+			map[string]interface{}{
+				UserCountKey: len(occupants),
+				MembersKey:   string(membersJSON),
+			},
+			now))
+	}
+	log.Printf("Writing %d points in %s measurement", len(points), ChannelOccupancyMeasurement)
+
+	return writeAPI.WritePoint(context.Background(), points...)
+}
+
+// GetUserVoiceTime returns the voice time of the user, given by ID so renames don't split the stats, in the range
+func (dm *DiscordMetrics) GetUserVoiceTime(userID, guildId string, r period.Range) (time.Duration, error) {
+	userSessions, err := dm.GetSessions(guildId, r,
+		flux.Eq(EventTypeKey, VoiceEvent),
+		flux.Eq(UserIdKey, userID),
+	)
+	if err != nil {
+		return 0, err
 	}
 
-	return totalDuration, nil
+	return sessions.Total(userSessions), nil
 }
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/identity"
+	"github.com/vcaldo/cerverox9/discord/pkg/tracker"
+)
+
+const (
+	UserNamesMeasurement = "user_names"
+	GlobalNameKey        = "global_name"
+	NickKey              = "nick"
+)
+
+// LogUserNames writes the names of the tracked members that changed since they were last written,
+// so every user ID keeps a history of the names it was known by
+func (dm *DiscordMetrics) LogUserNames(t *tracker.Tracker) error {
+	dm.namesMu.Lock()
+	defer dm.namesMu.Unlock()
+
+	if dm.knownNames == nil {
+		knownNames, err := dm.getLatestNames()
+		if err != nil {
+			return err
+		}
+		dm.knownNames = knownNames
+	}
+
+	now := time.Now()
+	changed := map[string]string{}
+	var points []*write.Point
+	for _, guildID := range t.GuildIDs() {
+		for _, member := range t.Members(guildID) {
+			key := guildID + "/" + member.UserID
+			names := namesSignature(member.Username, member.GlobalName, member.Nick)
+			if dm.knownNames[key] == names {
+				continue
+			}
+			changed[key] = names
+			points = append(points, influxdb2.NewPoint(UserNamesMeasurement,
+				map[string]string{
+					GuildIdKey: guildID,
+					UserIdKey:  member.UserID,
+				},
+				map[string]interface{}{
+					UsernameKey:        member.Username,
+					GlobalNameKey:      member.GlobalName,
+					NickKey:            member.Nick,
+					UserDisplayNameKey: member.DisplayName,
+				},
+				now))
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+
+	log.Printf("Writing %d points in %s measurement", len(points), UserNamesMeasurement)
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), points...)
+	if err != nil {
+		return fmt.Errorf("error logging user names: %v", err)
+	}
+	for key, names := range changed {
+		dm.knownNames[key] = names
+	}
+	return nil
+}
+
+// getLatestNames returns the last written names of every user, keyed by guild and user ID
+func (dm *DiscordMetrics) getLatestNames() (map[string]string, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.Eq("_measurement", UserNamesMeasurement)).
+		Pivot().
+		Group(GuildIdKey, UserIdKey).
+		Sort(true, "_time").
+		Limit(1)
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying for user names: %v", err)
+	}
+	defer result.Close()
+
+	knownNames := map[string]string{}
+	for result.Next() {
+		values := result.Record().Values()
+		guildID, _ := values[GuildIdKey].(string)
+		userID, _ := values[UserIdKey].(string)
+		username, _ := values[UsernameKey].(string)
+		globalName, _ := values[GlobalNameKey].(string)
+		nick, _ := values[NickKey].(string)
+		knownNames[guildID+"/"+userID] = namesSignature(username, globalName, nick)
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user names: %v", err)
+	}
+	return knownNames, nil
+}
+
+func namesSignature(username, globalName, nick string) string {
+	return username + "\x00" + globalName + "\x00" + nick
+}
+
+// GetKnownUsers returns every user of the guild with all the names they were known by, from the name history.
+// The names stored with the voice events from before the name history are copied into it by a migration.
+func (dm *DiscordMetrics) GetKnownUsers(guildID string) ([]identity.User, error) {
+	type knownUser struct {
+		user   identity.User
+		names  map[string]bool
+		latest time.Time
+	}
+	users := map[string]*knownUser{}
+	add := func(userID, username, displayName string, at time.Time, names ...string) {
+		if userID == "" {
+			return
+		}
+		k, ok := users[userID]
+		if !ok {
+			k = &knownUser{user: identity.User{UserID: userID}, names: map[string]bool{}}
+			users[userID] = k
+		}
+		if !at.Before(k.latest) {
+			k.latest = at
+			k.user.Username = username
+			k.user.DisplayName = displayName
+		}
+		for _, name := range append(names, username, displayName) {
+			if name != "" && !k.names[name] {
+				k.names[name] = true
+				k.user.Names = append(k.user.Names, name)
+			}
+		}
+	}
+
+	namesQuery := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", UserNamesMeasurement),
+			flux.Eq(GuildIdKey, guildID),
+		)).
+		Pivot()
+	err := dm.readNames(namesQuery, func(values map[string]interface{}, at time.Time) {
+		userID, _ := values[UserIdKey].(string)
+		username, _ := values[UsernameKey].(string)
+		displayName, _ := values[UserDisplayNameKey].(string)
+		globalName, _ := values[GlobalNameKey].(string)
+		nick, _ := values[NickKey].(string)
+		add(userID, username, displayName, at, globalName, nick)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	known := make([]identity.User, 0, len(users))
+	for _, k := range users {
+		known = append(known, k.user)
+	}
+	sort.Slice(known, func(i, j int) bool {
+		return known[i].UserID < known[j].UserID
+	})
+	return known, nil
+}
+
+func (dm *DiscordMetrics) readNames(query *flux.Query, fn func(values map[string]interface{}, at time.Time)) error {
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return fmt.Errorf("error querying for user names: %v", err)
+	}
+	defer result.Close()
+
+	for result.Next() {
+		fn(result.Record().Values(), result.Record().Time())
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("error iterating user names: %v", err)
+	}
+	return nil
+}
+
+// ResolveUser finds the user of the guild given by username, display name, nickname, mention or ID
+func (dm *DiscordMetrics) ResolveUser(guildID, query string) (identity.User, error) {
+	users, err := dm.GetKnownUsers(guildID)
+	if err != nil {
+		return identity.User{}, err
+	}
+	return identity.NewResolver(users).Resolve(query)
+}
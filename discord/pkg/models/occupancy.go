@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+	"github.com/vcaldo/cerverox9/discord/pkg/tracker"
+)
+
+// ChannelMember is the state of a user in a voice channel, stored as JSON in the members field of channel_occupancy
+type ChannelMember struct {
+	UserID      string    `json:"user_id"`
+	DisplayName string    `json:"display_name"`
+	Streaming   bool      `json:"streaming"`
+	Webcam      bool      `json:"webcam"`
+	Mute        bool      `json:"mute"`
+	Deaf        bool      `json:"deaf"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+type ChannelOccupancy struct {
+	ChannelID   string
+	ChannelName string
+	Members     []ChannelMember // Sorted by display name, or by join time when rebuilt from the events
+}
+
+// channelMembers groups the on call users of the snapshot by voice channel ID
+func channelMembers(snapshot tracker.Snapshot) map[string][]ChannelMember {
+	members := map[string][]ChannelMember{}
+	for _, member := range snapshot.Oncall {
+		members[member.ChannelID] = append(members[member.ChannelID], ChannelMember{
+			UserID:      member.UserID,
+			DisplayName: member.DisplayName,
+			Streaming:   member.SelfStream,
+			Webcam:      member.SelfVideo,
+			Mute:        member.SelfMute,
+			Deaf:        member.SelfDeaf,
+			JoinedAt:    member.JoinedAt,
+		})
+	}
+	return members
+}
+
+// GetChannelOccupancy returns the voice channels that have someone in them in the latest logged snapshot, sorted by name
+func (dm *DiscordMetrics) GetChannelOccupancy(guildID string) ([]ChannelOccupancy, error) {
+	query := flux.From(dm.Bucket).
+		RangeSince(10 * time.Minute).
+		Filter(flux.And(
+			flux.Eq("_measurement", ChannelOccupancyMeasurement),
+			flux.Eq(GuildIdKey, guildID),
+			flux.Eq("_field", MembersKey),
+		)).
+		Group(ChannelIdKey).
+		Last()
+
+	queryAPI := dm.Client.QueryAPI(dm.Org)
+	result, err := queryAPI.Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying for channel occupancy: %v", err)
+	}
+	defer result.Close()
+
+	// Every channel of a snapshot is written with the same time, channels whose
+	// last point is older than the latest snapshot were deleted or became ignored
+	var latest time.Time
+	byTime := map[time.Time][]ChannelOccupancy{}
+	for result.Next() {
+		record := result.Record()
+		members := []ChannelMember{}
+		value, _ := record.Value().(string)
+		err := json.Unmarshal([]byte(value), &members)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding members of channel %v: %v", record.ValueByKey(ChannelIdKey), err)
+		}
+		if record.Time().After(latest) {
+			latest = record.Time()
+		}
+		channelID, _ := record.ValueByKey(ChannelIdKey).(string)
+		channelName, _ := record.ValueByKey(ChannelNameKey).(string)
+		byTime[record.Time()] = append(byTime[record.Time()], ChannelOccupancy{
+			ChannelID:   channelID,
+			ChannelName: channelName,
+			Members:     members,
+		})
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating channel occupancy: %v", err)
+	}
+
+	occupied := []ChannelOccupancy{}
+	for _, channel := range byTime[latest] {
+		if len(channel.Members) > 0 {
+			occupied = append(occupied, channel)
+		}
+	}
+	sort.Slice(occupied, func(i, j int) bool {
+		return occupied[i].ChannelName < occupied[j].ChannelName
+	})
+	return occupied, nil
+}
+
+// GetOccupancyAt rebuilds who was in each voice channel at the time from the voice events, sorted by channel name.
+// The JoinedAt of the members is the start of their session.
+func (dm *DiscordMetrics) GetOccupancyAt(guildID string, t time.Time) ([]ChannelOccupancy, error) {
+	// Sessions open at t started at most a max session before it
+	r := period.Range{Start: t.Add(-dm.maxSession()), Stop: t.Add(time.Second)}
+	guildSessions, err := dm.GetSessions(guildID, r)
+	if err != nil {
+		return nil, err
+	}
+
+	openAt := func(eventType string) []sessions.Session {
+		var open []sessions.Session
+		for _, s := range sessions.Filter(guildSessions, eventType) {
+			if !s.Start.After(t) && s.End.After(t) {
+				open = append(open, s)
+			}
+		}
+		return open
+	}
+	streaming, webcam, mute, deaf := openAt(StreamEvent), openAt(WebcamEvent), openAt(MuteEvent), openAt(DeafenEvent)
+	hasOpen := func(voice sessions.Session, media []sessions.Session) bool {
+		for _, s := range media {
+			if s.UserID == voice.UserID && s.ChannelID == voice.ChannelID {
+				return true
+			}
+		}
+		return false
+	}
+
+	byChannel := map[string]*ChannelOccupancy{}
+	for _, s := range openAt(VoiceEvent) {
+		channel, ok := byChannel[s.ChannelID]
+		if !ok {
+			channel = &ChannelOccupancy{ChannelID: s.ChannelID, ChannelName: s.ChannelName}
+			byChannel[s.ChannelID] = channel
+		}
+		displayName := s.DisplayName
+		if displayName == "" {
+			displayName = s.Username
+		}
+		channel.Members = append(channel.Members, ChannelMember{
+			UserID:      s.UserID,
+			DisplayName: displayName,
+			Streaming:   hasOpen(s, streaming),
+			Webcam:      hasOpen(s, webcam),
+			Mute:        hasOpen(s, mute),
+			Deaf:        hasOpen(s, deaf),
+			JoinedAt:    s.Start,
+		})
+	}
+
+	occupied := make([]ChannelOccupancy, 0, len(byChannel))
+	for _, channel := range byChannel {
+		sort.Slice(channel.Members, func(i, j int) bool {
+			return channel.Members[i].JoinedAt.Before(channel.Members[j].JoinedAt)
+		})
+		occupied = append(occupied, *channel)
+	}
+	sort.Slice(occupied, func(i, j int) bool {
+		return occupied[i].ChannelName < occupied[j].ChannelName
+	})
+	return occupied, nil
+}
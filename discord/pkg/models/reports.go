@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+)
+
+const (
+	ReportPostsMeasurement = "report_posts"
+	ReportKey              = "report"
+	ReportPeriodKey        = "period"
+	PostedKey              = "posted"
+	WeeklyReport           = "weekly"
+	MonthlyReport          = "monthly"
+	WrappedReport          = "wrapped" // Posted on December 31 to the notifications chat, not scheduled per chat
+	reportScheduleSuffix   = "_report" // Schedules are stored in chat_settings as the weekly_report and monthly_report fields
+)
+
+var Reports = []string{WeeklyReport, MonthlyReport}
+
+// ReportSchedule is a report a Telegram chat gets, posted at the time of day in the chat time zone
+type ReportSchedule struct {
+	ChatID int64
+	Report string
+	At     string // HH:MM
+}
+
+// SetReportSchedule schedules the report for the chat at the time of day as HH:MM, an empty time turns it off
+func (dm *DiscordMetrics) SetReportSchedule(chatID int64, report, at string) error {
+	p := influxdb2.NewPoint(ChatSettingsMeasurement,
+		map[string]string{
+			ChatIdKey: strconv.FormatInt(chatID, 10),
+		},
+		map[string]interface{}{
+			report + reportScheduleSuffix: at,
+		},
+		time.Now())
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), p)
+	if err != nil {
+		return fmt.Errorf("error logging report schedule: %v", err)
+	}
+	return nil
+}
+
+// GetReportSchedules returns the reports scheduled in every chat, sorted by chat and report
+func (dm *DiscordMetrics) GetReportSchedules() ([]ReportSchedule, error) {
+	fields := make([]string, 0, len(Reports))
+	for _, report := range Reports {
+		fields = append(fields, report+reportScheduleSuffix)
+	}
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", ChatSettingsMeasurement),
+			flux.In("_field", fields...),
+		)).
+		Last()
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying for report schedules: %v", err)
+	}
+	defer result.Close()
+
+	var schedules []ReportSchedule
+	for result.Next() {
+		record := result.Record()
+		at, _ := record.Value().(string)
+		chatID, err := strconv.ParseInt(fmt.Sprint(record.ValueByKey(ChatIdKey)), 10, 64)
+		if at == "" || err != nil {
+			continue
+		}
+		schedules = append(schedules, ReportSchedule{
+			ChatID: chatID,
+			Report: record.Field()[:len(record.Field())-len(reportScheduleSuffix)],
+			At:     at,
+		})
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating report schedules: %v", err)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].ChatID != schedules[j].ChatID {
+			return schedules[i].ChatID < schedules[j].ChatID
+		}
+		return schedules[i].Report < schedules[j].Report
+	})
+	return schedules, nil
+}
+
+// IsReportPosted reports whether the report of the period, such as 2024-W05 or 2024-01, was posted to the chat
+func (dm *DiscordMetrics) IsReportPosted(chatID int64, report, reportPeriod string) (bool, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", ReportPostsMeasurement),
+			flux.Eq(ChatIdKey, strconv.FormatInt(chatID, 10)),
+			flux.Eq(ReportKey, report),
+			flux.Eq(ReportPeriodKey, reportPeriod),
+			flux.Eq("_field", PostedKey),
+		)).
+		Last()
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return false, fmt.Errorf("error querying for report posts: %v", err)
+	}
+	defer result.Close()
+
+	posted := false
+	for result.Next() {
+		posted, _ = result.Record().Value().(bool)
+	}
+	if err := result.Err(); err != nil {
+		return false, fmt.Errorf("error iterating report posts: %v", err)
+	}
+	return posted, nil
+}
+
+// LogReportPost records that the report of the period was posted to the chat, so it isn't posted again
+func (dm *DiscordMetrics) LogReportPost(chatID int64, report, reportPeriod string) error {
+	p := influxdb2.NewPoint(ReportPostsMeasurement,
+		map[string]string{
+			ChatIdKey:       strconv.FormatInt(chatID, 10),
+			ReportKey:       report,
+			ReportPeriodKey: reportPeriod,
+		},
+		map[string]interface{}{
+			PostedKey: true,
+		},
+		time.Now())
+	err := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket).WritePoint(context.Background(), p)
+	if err != nil {
+		return fmt.Errorf("error logging report post: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+)
+
+const (
+	GuildRolesMeasurement = "guild_roles"
+	RoleIdKey             = "role_id"
+	RoleNameKey           = "role_name"
+)
+
+type RoleVoiceTime struct {
+	RoleID   string
+	RoleName string
+	Total    time.Duration
+	Members  map[string]time.Duration // Voice time keyed by user ID
+	Names    map[string]string        // Latest display name keyed by user ID
+}
+
+// LogGuildRoles stores the role names so stats can resolve the role IDs stored on voice events
+func (dm *DiscordMetrics) LogGuildRoles(guildID string, roles []*discordgo.Role) error {
+	writeAPI := dm.Client.WriteAPIBlocking(dm.Org, dm.Bucket)
+
+	now := time.Now()
+	for _, role := range roles {
+		// The @everyone role shares the guild ID and is never part of the member roles
+		if role.ID == guildID {
+			continue
+		}
+		p := influxdb2.NewPoint(GuildRolesMeasurement,
+			map[string]string{
+				GuildIdKey: guildID,
+				RoleIdKey:  role.ID,
+			},
+			map[string]interface{}{
+				RoleNameKey: role.Name,
+			},
+			now)
+		if err := writeAPI.WritePoint(context.Background(), p); err != nil {
+			return fmt.Errorf("error logging role %s: %v", role.ID, err)
+		}
+	}
+	log.Printf("Logged %d roles for guild %s", len(roles), guildID)
+	return nil
+}
+
+// GetGuildRoles returns the latest known role names keyed by role ID
+func (dm *DiscordMetrics) GetGuildRoles(guildID string) (map[string]string, error) {
+	query := flux.From(dm.Bucket).
+		RangeAll().
+		Filter(flux.And(
+			flux.Eq("_measurement", GuildRolesMeasurement),
+			flux.Eq(GuildIdKey, guildID),
+			flux.Eq("_field", RoleNameKey),
+		)).
+		Last()
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying for guild roles: %v", err)
+	}
+	defer result.Close()
+
+	roles := map[string]string{}
+	for result.Next() {
+		record := result.Record()
+		roleID, ok1 := record.ValueByKey(RoleIdKey).(string)
+		roleName, ok2 := record.Value().(string)
+		if !ok1 || !ok2 {
+			continue
+		}
+		roles[roleID] = roleName
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating roles: %v", err)
+	}
+	return roles, nil
+}
+
+// GetRoleVoiceTime returns the voice time in the range grouped by the roles members had when they joined,
+// sorted by total voice time. Members with several roles count towards each of them.
+func (dm *DiscordMetrics) GetRoleVoiceTime(guildID string, r period.Range) ([]RoleVoiceTime, error) {
+	roleNames, err := dm.GetGuildRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	voiceSessions, err := dm.GetSessions(guildID, r, flux.Eq(EventTypeKey, VoiceEvent))
+	if err != nil {
+		return nil, err
+	}
+
+	byRole := map[string]*RoleVoiceTime{}
+	for _, session := range voiceSessions {
+		// Events stored before roles were tracked can't be grouped
+		if !session.HasRoles {
+			continue
+		}
+		for _, roleID := range session.RoleIDs {
+			role, ok := byRole[roleID]
+			if !ok {
+				role = &RoleVoiceTime{
+					RoleID:   roleID,
+					RoleName: roleNames[roleID],
+					Members:  map[string]time.Duration{},
+					Names:    map[string]string{},
+				}
+				if role.RoleName == "" {
+					role.RoleName = roleID
+				}
+				byRole[roleID] = role
+			}
+			role.Total += session.Duration()
+			// Events stored before users were tracked by ID fall back to the username
+			userID := session.UserID
+			if userID == "" {
+				userID = session.Username
+			}
+			role.Members[userID] += session.Duration()
+			role.Names[userID] = session.DisplayName
+			if role.Names[userID] == "" {
+				role.Names[userID] = session.Username
+			}
+		}
+	}
+
+	roles := make([]RoleVoiceTime, 0, len(byRole))
+	for _, role := range byRole {
+		roles = append(roles, *role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Total > roles[j].Total
+	})
+	return roles, nil
+}
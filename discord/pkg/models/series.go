@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+)
+
+// GetUsersCountSeries returns the user count of a measurement such as oncall_users in the range,
+// aggregated in windows of the given duration with a Flux aggregate such as mean or max
+func (dm *DiscordMetrics) GetUsersCountSeries(measurement, guildID string, r period.Range, every time.Duration, fn string) ([]analytics.Point, error) {
+	start := r.Start
+	if start.IsZero() {
+		start = time.Unix(0, 0)
+	}
+
+	// Points of every series of the guild are merged before aggregating
+	query := flux.From(dm.Bucket).
+		Range(start, r.Stop).
+		Filter(flux.And(
+			flux.Eq("_measurement", measurement),
+			flux.Eq(GuildIdKey, guildID),
+			flux.Eq("_field", UserCountKey),
+		)).
+		Group(GuildIdKey).
+		Sort(false, "_time").
+		AggregateWindow(every, fn, false)
+
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying for %s series: %v", measurement, err)
+	}
+	defer result.Close()
+
+	var points []analytics.Point
+	for result.Next() {
+		record := result.Record()
+		var value float64
+		switch v := record.Value().(type) {
+		case float64:
+			value = v
+		case int64:
+			value = float64(v)
+		default:
+			continue
+		}
+		points = append(points, analytics.Point{Time: record.Time(), Value: value})
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s series: %v", measurement, err)
+	}
+	return points, nil
+}
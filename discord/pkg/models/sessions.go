@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/filters"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// GetSessions rebuilds the sessions of the guild from the voice events matching the predicates, clipped to the range.
+// Every duration stat goes through it so missed events, ignored channels and short disconnects are handled the same way.
+func (dm *DiscordMetrics) GetSessions(guildID string, r period.Range, predicates ...flux.Predicate) ([]sessions.Session, error) {
+	events, err := dm.readVoiceEvents(dm.voiceEventsQuery(guildID, r, predicates...))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := dm.Sessions
+	opts.IgnoreChannel = func(e sessions.Event) bool {
+		return dm.Rules.IgnoresChannel(filters.Subject{ChannelID: e.ChannelID, ChannelName: e.ChannelName})
+	}
+	return sessions.Clip(sessions.Reconstruct(events, opts), r), nil
+}
+
+// voiceEventsQuery reads the voice events of a guild needed to rebuild the sessions in the range, as one table sorted by time.
+// Events are read up to the max session length around the range so sessions crossing its edges can be clipped to it.
+func (dm *DiscordMetrics) voiceEventsQuery(guildID string, r period.Range, predicates ...flux.Predicate) *flux.Query {
+	lookback := dm.maxSession()
+
+	start := time.Unix(0, 0)
+	if !r.Start.IsZero() {
+		start = r.Start.Add(-lookback)
+	}
+	var stop time.Time
+	if !r.Stop.IsZero() && r.Stop.Add(lookback).Before(time.Now()) {
+		stop = r.Stop.Add(lookback)
+	}
+
+	return flux.From(dm.Bucket).
+		Range(start, stop).
+		Filter(flux.And(append([]flux.Predicate{
+			flux.Eq("_measurement", VoiceEventsMeasurement),
+			flux.Eq(GuildIdKey, guildID),
+		}, predicates...)...)).
+		Pivot().
+		Group().
+		Sort(false, "_time")
+}
+
+// readVoiceEvents runs a voice events query, leaving out the users matched by the filtering rules.
+// Channels are left to the session reconstruction so moves into ignored channels end sessions.
+func (dm *DiscordMetrics) readVoiceEvents(query *flux.Query) ([]sessions.Event, error) {
+	result, err := dm.Client.QueryAPI(dm.Org).Query(context.Background(), query.String())
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer result.Close()
+
+	var events []sessions.Event
+	for result.Next() {
+		record := result.Record()
+		subject := recordSubject(record.Values())
+		if dm.Rules.IgnoresUser(subject) {
+			continue
+		}
+		state, ok := record.ValueByKey(StateKey).(bool)
+		if !ok {
+			continue
+		}
+		eventType, _ := record.ValueByKey(EventTypeKey).(string)
+
+		events = append(events, sessions.Event{
+			Time:        record.Time(),
+			UserID:      subject.UserID,
+			Username:    subject.Username,
+			DisplayName: subject.DisplayName,
+			ChannelID:   subject.ChannelID,
+			ChannelName: subject.ChannelName,
+			EventType:   eventType,
+			State:       state,
+			RoleIDs:     subject.RoleIDs,
+			HasRoles:    subject.HasRoles,
+		})
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating voice events: %v", err)
+	}
+	return events, nil
+}
+
+// maxSession is the longest a session can last, events further apart than it can't be in the same session
+func (dm *DiscordMetrics) maxSession() time.Duration {
+	if dm.Sessions.MaxSession <= 0 {
+		return sessions.DefaultMaxSession
+	}
+	return dm.Sessions.MaxSession
+}
@@ -1,16 +1,33 @@
 package models
 
 import (
-	"github.com/bwmarrin/discordgo"
+	"strings"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/filters"
 )
 
-func userDisplayName(m *discordgo.Member) string {
-	switch {
-	case m.Nick != "":
-		return m.Nick
-	case m.User.GlobalName != "":
-		return m.User.GlobalName
-	default:
-		return m.User.Username
+// recordSubject describes a stored voice event for the filtering rules using the tags written with it
+func recordSubject(values map[string]interface{}) filters.Subject {
+	tag := func(key string) string {
+		value, _ := values[key].(string)
+		return value
+	}
+	roleIDs, hasRoles := values[RoleIdsKey].(string)
+	return filters.Subject{
+		UserID:      tag(UserIdKey),
+		Username:    tag(UsernameKey),
+		DisplayName: tag(UserDisplayNameKey),
+		RoleIDs:     splitRoleIDs(roleIDs),
+		HasRoles:    hasRoles,
+		ChannelID:   tag(ChannelIdKey),
+		ChannelName: tag(ChannelNameKey),
+	}
+}
+
+// splitRoleIDs parses the comma separated role_ids field, role IDs are snowflakes so they can't have a comma
+func splitRoleIDs(roleIDs string) []string {
+	if roleIDs == "" {
+		return []string{}
 	}
+	return strings.Split(roleIDs, ",")
 }
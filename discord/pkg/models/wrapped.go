@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+// GetWrapped builds the year in review of the range for the user given by ID, or for the guild when the ID is empty.
+// Every session of the guild is read, the buddies of the user need everyone's.
+func (dm *DiscordMetrics) GetWrapped(guildID, userID string, r period.Range, loc *time.Location) (analytics.Wrapped, error) {
+	yearSessions, err := dm.GetSessions(guildID, r, flux.In(EventTypeKey, VoiceEvent, StreamEvent, WebcamEvent))
+	if err != nil {
+		return analytics.Wrapped{}, err
+	}
+
+	return analytics.NewWrapped(
+		sessions.Filter(yearSessions, VoiceEvent),
+		sessions.Filter(yearSessions, StreamEvent),
+		sessions.Filter(yearSessions, WebcamEvent),
+		userID,
+		loc,
+	), nil
+}
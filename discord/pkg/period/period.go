@@ -0,0 +1,227 @@
+// Package period parses the time ranges stats are computed for.
+package period
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // The bot images don't ship a time zone database
+)
+
+const (
+	Usage     = "today, yesterday, week, month, year, all, a rolling window like 24h, 7d or 4w, or <from> <to> dates as YYYY-MM-DD"
+	TimeUsage = "HH:MM, yesterday HH:MM, YYYY-MM-DD HH:MM or YYYY-MM-DDTHH:MM"
+)
+
+var dateLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// Range is a time range, a zero Start means since the beginning and a zero Stop means until now
+type Range struct {
+	Start time.Time
+	Stop  time.Time
+	Label string // Completes sentences like "voice time for user x <label>"
+}
+
+// LocationFromEnv returns the time zone set in STATS_TIMEZONE, UTC when it's not set
+func LocationFromEnv() *time.Location {
+	name, ok := os.LookupEnv("STATS_TIMEZONE")
+	if !ok || name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Fatalf("STATS_TIMEZONE is not a valid time zone: %v", err)
+	}
+	return loc
+}
+
+// Parse reads a range from command arguments, calendar periods start at midnight in the given time zone.
+// No arguments means the current year.
+func Parse(args []string, now time.Time, loc *time.Location) (Range, error) {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	switch len(args) {
+	case 0:
+		return Year(now), nil
+	case 2:
+		return parseDates(args[0], args[1], loc)
+	case 1:
+	default:
+		return Range{}, fmt.Errorf("too many arguments, use %s", Usage)
+	}
+
+	switch arg := strings.ToLower(args[0]); arg {
+	case "today":
+		return Range{Start: today, Label: "today"}, nil
+	case "yesterday":
+		return Range{Start: today.AddDate(0, 0, -1), Stop: today, Label: "yesterday"}, nil
+	case "week":
+		return Week(now), nil
+	case "month":
+		return Month(now), nil
+	case "year":
+		return Year(now), nil
+	case "all":
+		return Range{Label: "of all time"}, nil
+	default:
+		window, err := parseWindow(arg)
+		if err != nil {
+			return Range{}, err
+		}
+		return Range{Start: now.Add(-window), Label: fmt.Sprintf("in the last %s", arg)}, nil
+	}
+}
+
+// ParseTime reads a point in time from command arguments in the given time zone.
+// A time of day alone is the latest one that already happened, so 23:00 in the morning is last night.
+func ParseTime(args []string, now time.Time, loc *time.Location) (time.Time, error) {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	switch len(args) {
+	case 1:
+		if t, _, err := parseDate(args[0], loc); err == nil {
+			return t, nil
+		}
+		hour, minute, err := parseClock(args[0])
+		if err != nil {
+			return time.Time{}, err
+		}
+		t := atClock(today, hour, minute)
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1)
+		}
+		return t, nil
+	case 2:
+		hour, minute, err := parseClock(args[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch strings.ToLower(args[0]) {
+		case "today":
+			return atClock(today, hour, minute), nil
+		case "yesterday":
+			return atClock(today.AddDate(0, 0, -1), hour, minute), nil
+		}
+		day, dateOnly, err := parseDate(args[0], loc)
+		if err != nil || !dateOnly {
+			return time.Time{}, fmt.Errorf("invalid date %q, use %s", args[0], TimeUsage)
+		}
+		return atClock(day, hour, minute), nil
+	default:
+		return time.Time{}, fmt.Errorf("use %s", TimeUsage)
+	}
+}
+
+// parseClock parses a time of day as HH:MM
+func parseClock(value string) (int, int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %q, use %s", value, TimeUsage)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// atClock is the time of day on the day, which isn't midnight plus the time on days the clocks change
+func atClock(day time.Time, hour, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+// Week is the calendar week of the time, starting on Monday
+func Week(t time.Time) Range {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	return Range{Start: start, Stop: start.AddDate(0, 0, 7), Label: "this week"}
+}
+
+// Month is the calendar month of the time
+func Month(t time.Time) Range {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return Range{Start: start, Stop: start.AddDate(0, 1, 0), Label: "this month"}
+}
+
+// Year is the calendar year of the time
+func Year(t time.Time) Range {
+	start := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	return Range{Start: start, Stop: start.AddDate(1, 0, 0), Label: "this year"}
+}
+
+// Clip returns the part of [start, end) that falls in the range
+func (r Range) Clip(start, end time.Time) (time.Time, time.Time, bool) {
+	if !r.Start.IsZero() && start.Before(r.Start) {
+		start = r.Start
+	}
+	if !r.Stop.IsZero() && end.After(r.Stop) {
+		end = r.Stop
+	}
+	return start, end, end.After(start)
+}
+
+// Contains reports whether the time falls in the range
+func (r Range) Contains(t time.Time) bool {
+	return (r.Start.IsZero() || !t.Before(r.Start)) && (r.Stop.IsZero() || t.Before(r.Stop))
+}
+
+// parseWindow parses rolling windows such as 24h, 7d or 4w
+func parseWindow(arg string) (time.Duration, error) {
+	if len(arg) < 2 {
+		return 0, fmt.Errorf("unknown period %q, use %s", arg, Usage)
+	}
+	n, err := strconv.Atoi(arg[:len(arg)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("unknown period %q, use %s", arg, Usage)
+	}
+	var unit time.Duration
+	switch arg[len(arg)-1] {
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("unknown period %q, use %s", arg, Usage)
+	}
+	// Longer windows overflow a time.Duration, which tops out at about 292 years
+	if int64(n) > math.MaxInt64/int64(unit) {
+		return 0, fmt.Errorf("period %q is too long, use all for the whole history", arg)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// parseDates parses a from/to pair, dates without a time include the whole "to" day
+func parseDates(from, to string, loc *time.Location) (Range, error) {
+	start, _, err := parseDate(from, loc)
+	if err != nil {
+		return Range{}, err
+	}
+	stop, dateOnly, err := parseDate(to, loc)
+	if err != nil {
+		return Range{}, err
+	}
+	if dateOnly {
+		stop = stop.AddDate(0, 0, 1)
+	}
+	if !stop.After(start) {
+		return Range{}, fmt.Errorf("%s is not after %s", to, from)
+	}
+	return Range{Start: start, Stop: stop, Label: fmt.Sprintf("from %s to %s", from, to)}, nil
+}
+
+func parseDate(value string, loc *time.Location) (time.Time, bool, error) {
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, layout == "2006-01-02", nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date %q, use YYYY-MM-DD", value)
+}
@@ -0,0 +1,163 @@
+package period
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, newYork)
+	}
+	// Noon on the day the clocks spring forward, and on the day they fall back
+	spring := at(2024, time.March, 10, 12)
+	fall := at(2024, time.November, 3, 12)
+
+	tests := []struct {
+		args    []string
+		now     time.Time
+		start   time.Time
+		stop    time.Time
+		label   string
+		wantErr bool
+	}{
+		{args: nil, now: spring, start: at(2024, time.January, 1, 0), stop: at(2025, time.January, 1, 0), label: "this year"},
+		{args: []string{"today"}, now: spring, start: at(2024, time.March, 10, 0), label: "today"},
+		{args: []string{"Yesterday"}, now: spring, start: at(2024, time.March, 9, 0), stop: at(2024, time.March, 10, 0), label: "yesterday"},
+		{args: []string{"yesterday"}, now: at(2024, time.November, 4, 12), start: at(2024, time.November, 3, 0), stop: at(2024, time.November, 4, 0), label: "yesterday"},
+		{args: []string{"week"}, now: spring, start: at(2024, time.March, 4, 0), stop: at(2024, time.March, 11, 0), label: "this week"},
+		{args: []string{"week"}, now: at(2024, time.March, 11, 0), start: at(2024, time.March, 11, 0), stop: at(2024, time.March, 18, 0), label: "this week"},
+		{args: []string{"month"}, now: fall, start: at(2024, time.November, 1, 0), stop: at(2024, time.December, 1, 0), label: "this month"},
+		{args: []string{"year"}, now: fall, start: at(2024, time.January, 1, 0), stop: at(2025, time.January, 1, 0), label: "this year"},
+		{args: []string{"all"}, now: fall, label: "of all time"},
+		// Rolling windows are elapsed time, so 24h on the day the clocks fall back starts at 13:00 the day before
+		{args: []string{"24h"}, now: fall, start: at(2024, time.November, 2, 13), label: "in the last 24h"},
+		{args: []string{"1d"}, now: spring, start: at(2024, time.March, 9, 11), label: "in the last 1d"},
+		{args: []string{"2w"}, now: fall, start: fall.Add(-14 * 24 * time.Hour), label: "in the last 2w"},
+		{args: []string{"2562047h"}, now: fall, start: fall.Add(-2562047 * time.Hour), label: "in the last 2562047h"},
+		{args: []string{"106751d"}, now: fall, start: fall.Add(-106751 * 24 * time.Hour), label: "in the last 106751d"},
+		{args: []string{"15250w"}, now: fall, start: fall.Add(-15250 * 7 * 24 * time.Hour), label: "in the last 15250w"},
+		{args: []string{"2562048h"}, now: fall, wantErr: true},
+		{args: []string{"106752d"}, now: fall, wantErr: true},
+		{args: []string{"15251w"}, now: fall, wantErr: true},
+		{args: []string{"999999w"}, now: fall, wantErr: true},
+		{args: []string{"99999999999999999999h"}, now: fall, wantErr: true},
+		{args: []string{"0d"}, now: fall, wantErr: true},
+		{args: []string{"-1d"}, now: fall, wantErr: true},
+		{args: []string{"5m"}, now: fall, wantErr: true},
+		{args: []string{"d"}, now: fall, wantErr: true},
+		{args: []string{"alice"}, now: fall, wantErr: true},
+		// Dates without a time include the whole "to" day, which is 25 hours long when the clocks fall back
+		{args: []string{"2024-11-01", "2024-11-03"}, now: fall, start: at(2024, time.November, 1, 0), stop: at(2024, time.November, 4, 0), label: "from 2024-11-01 to 2024-11-03"},
+		{args: []string{"2024-03-10T01:00", "2024-03-10T04:00"}, now: fall, start: at(2024, time.March, 10, 1), stop: at(2024, time.March, 10, 4), label: "from 2024-03-10T01:00 to 2024-03-10T04:00"},
+		{args: []string{"2024-11-03", "2024-11-01"}, now: fall, wantErr: true},
+		{args: []string{"2024-11-01", "tomorrow"}, now: fall, wantErr: true},
+		{args: []string{"a", "b", "c"}, now: fall, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r, err := Parse(tt.args, tt.now, newYork)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", tt.args, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", tt.args, err)
+			continue
+		}
+		if !r.Start.Equal(tt.start) || !r.Stop.Equal(tt.stop) || r.Label != tt.label {
+			t.Errorf("Parse(%q) = %v to %v %q, want %v to %v %q", tt.args, r.Start, r.Stop, r.Label, tt.start, tt.stop, tt.label)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, newYork)
+	}
+	now := at(2024, time.March, 10, 12, 0)
+
+	tests := []struct {
+		args    []string
+		want    time.Time
+		wantErr bool
+	}{
+		{args: []string{"09:30"}, want: at(2024, time.March, 10, 9, 30)},
+		{args: []string{"9:30"}, want: at(2024, time.March, 10, 9, 30)},
+		{args: []string{"12:00"}, want: at(2024, time.March, 10, 12, 0)},
+		{args: []string{"23:00"}, want: at(2024, time.March, 9, 23, 0)}, // Later than now is last night
+		{args: []string{"today", "01:00"}, want: at(2024, time.March, 10, 1, 0)},
+		{args: []string{"yesterday", "22:15"}, want: at(2024, time.March, 9, 22, 15)},
+		{args: []string{"2024-03-01", "08:00"}, want: at(2024, time.March, 1, 8, 0)},
+		{args: []string{"2024-03-01T08:00"}, want: at(2024, time.March, 1, 8, 0)},
+		{args: []string{"2024-03-01"}, want: at(2024, time.March, 1, 0, 0)},
+		// Times of day on the days the clocks change are read on the clock, not as elapsed time since midnight
+		{args: []string{"2024-03-10", "04:00"}, want: time.Date(2024, time.March, 10, 8, 0, 0, 0, time.UTC)},
+		{args: []string{"2024-11-03", "04:00"}, want: time.Date(2024, time.November, 3, 9, 0, 0, 0, time.UTC)},
+		{args: []string{"today", "04:00"}, want: time.Date(2024, time.March, 10, 8, 0, 0, 0, time.UTC)},
+		{args: nil, wantErr: true},
+		{args: []string{"25:00"}, wantErr: true},
+		{args: []string{"noon"}, wantErr: true},
+		{args: []string{"yesterday"}, wantErr: true},
+		{args: []string{"2024-03-01T08:00", "09:00"}, wantErr: true},
+		{args: []string{"tomorrow", "09:00"}, wantErr: true},
+		{args: []string{"2024-03-01", "09:00", "x"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTime(tt.args, now, newYork)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseTime(%q) = %v, want an error", tt.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTime(%q) returned %v", tt.args, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestClip(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.March, d, 0, 0, 0, 0, time.UTC) }
+	r := Range{Start: day(10), Stop: day(20)}
+
+	tests := []struct {
+		name       string
+		r          Range
+		start, end time.Time
+		wantStart  time.Time
+		wantEnd    time.Time
+		wantOK     bool
+	}{
+		{"inside", r, day(12), day(14), day(12), day(14), true},
+		{"across the start", r, day(5), day(12), day(10), day(12), true},
+		{"across the stop", r, day(18), day(25), day(18), day(20), true},
+		{"across both edges", r, day(5), day(25), day(10), day(20), true},
+		{"ends at the start", r, day(5), day(10), day(10), day(10), false},
+		{"starts at the stop", r, day(20), day(25), day(20), day(20), false},
+		{"before", r, day(1), day(5), day(10), day(5), false},
+		{"open range", Range{}, day(1), day(5), day(1), day(5), true},
+	}
+
+	for _, tt := range tests {
+		start, end, ok := tt.r.Clip(tt.start, tt.end)
+		if ok != tt.wantOK || (ok && (!start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd))) {
+			t.Errorf("%s: Clip = %v, %v, %v, want %v, %v, %v", tt.name, start, end, ok, tt.wantStart, tt.wantEnd, tt.wantOK)
+		}
+	}
+}
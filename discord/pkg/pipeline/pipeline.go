@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// Pipeline runs jobs in the order they were submitted for each key, usually a user ID.
+// Keys are spread over a fixed set of queues so jobs of different users run concurrently
+// while the jobs of a single user never overtake each other.
+type Pipeline struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+func NewPipeline(workers, queueSize int) *Pipeline {
+	p := &Pipeline{
+		queues: make([]chan func(), workers),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queueSize)
+	}
+	return p
+}
+
+// Start runs one worker per queue until the context is done, then drains what is left
+func (p *Pipeline) Start(ctx context.Context) {
+	for _, queue := range p.queues {
+		p.wg.Add(1)
+		go func(queue chan func()) {
+			defer p.wg.Done()
+			for {
+				select {
+				case job := <-queue:
+					job()
+				case <-ctx.Done():
+					for {
+						select {
+						case job := <-queue:
+							job()
+						default:
+							return
+						}
+					}
+				}
+			}
+		}(queue)
+	}
+}
+
+// Wait blocks until every worker returned after the context passed to Start is done
+func (p *Pipeline) Wait() {
+	p.wg.Wait()
+}
+
+// Submit queues a job behind every job previously submitted with the same key and reports whether it was queued.
+// It never blocks, as it's called from the gateway event handlers: when the queue is full the job is dropped.
+func (p *Pipeline) Submit(key string, job func()) bool {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	select {
+	case p.queues[h.Sum32()%uint32(len(p.queues))] <- job:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,212 @@
+// Package rewards gives Discord roles to the members whose voice stats qualify for them,
+// and takes the roles back when they don't qualify anymore.
+package rewards
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/tracker"
+)
+
+const (
+	defaultRegularHours = 10
+	defaultInterval     = time.Hour
+	regularWindow       = 30 * 24 * time.Hour
+)
+
+type Config struct {
+	TalkerRoleID  string        // Given to the voice leaderboard winner of last month, off when empty
+	RegularRoleID string        // Given to everyone with RegularTime on call in the last 30 days, off when empty
+	RegularTime   time.Duration // 10 hours by default
+	Interval      time.Duration // How often the roles are checked, every hour by default
+	DryRun        bool          // Log the changes without applying them
+}
+
+// ConfigFromEnv reads DISCORD_REWARD_TALKER_ROLE_ID, DISCORD_REWARD_REGULAR_ROLE_ID, DISCORD_REWARD_REGULAR_HOURS,
+// DISCORD_REWARD_INTERVAL and DISCORD_REWARD_DRY_RUN
+func ConfigFromEnv() Config {
+	config := Config{
+		TalkerRoleID:  os.Getenv("DISCORD_REWARD_TALKER_ROLE_ID"),
+		RegularRoleID: os.Getenv("DISCORD_REWARD_REGULAR_ROLE_ID"),
+		RegularTime:   defaultRegularHours * time.Hour,
+		Interval:      defaultInterval,
+	}
+
+	if value, ok := os.LookupEnv("DISCORD_REWARD_REGULAR_HOURS"); ok && value != "" {
+		hours, err := strconv.ParseFloat(value, 64)
+		if err != nil || hours <= 0 {
+			log.Fatal("DISCORD_REWARD_REGULAR_HOURS must be a positive number of hours")
+		}
+		config.RegularTime = time.Duration(hours * float64(time.Hour))
+	}
+	if value, ok := os.LookupEnv("DISCORD_REWARD_INTERVAL"); ok && value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			log.Fatalf("DISCORD_REWARD_INTERVAL is not a valid duration: %v", value)
+		}
+		config.Interval = interval
+	}
+	if value, ok := os.LookupEnv("DISCORD_REWARD_DRY_RUN"); ok && value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatal("DISCORD_REWARD_DRY_RUN must be true or false")
+		}
+		config.DryRun = dryRun
+	}
+	return config
+}
+
+// Change gives a role to a member, or takes it from them
+type Change struct {
+	GuildID     string
+	UserID      string
+	DisplayName string
+	RoleID      string
+	Add         bool
+}
+
+func (c Change) String() string {
+	if c.Add {
+		return fmt.Sprintf("add role %s to %s (%s) in guild %s", c.RoleID, c.DisplayName, c.UserID, c.GuildID)
+	}
+	return fmt.Sprintf("remove role %s from %s (%s) in guild %s", c.RoleID, c.DisplayName, c.UserID, c.GuildID)
+}
+
+// Plan returns the changes that give the role to the qualified members and take it from the others
+func Plan(guildID, roleID string, members []tracker.Member, qualified map[string]bool) []Change {
+	var changes []Change
+	for _, member := range members {
+		has := slices.Contains(member.RoleIDs, roleID)
+		if qualified[member.UserID] != has {
+			changes = append(changes, Change{
+				GuildID:     guildID,
+				UserID:      member.UserID,
+				DisplayName: member.DisplayName,
+				RoleID:      roleID,
+				Add:         !has,
+			})
+		}
+	}
+	return changes
+}
+
+// LastMonth is the calendar month before the one of the time in the time zone
+func LastMonth(now time.Time, loc *time.Location) period.Range {
+	return period.Month(period.Month(now.In(loc)).Start.AddDate(0, -1, 0))
+}
+
+// Rewarder keeps the reward roles of every tracked guild in line with the stats.
+// Members ignored by the filtering rules are left alone, and the bot needs the Manage Roles
+// permission with its role above the reward roles.
+type Rewarder struct {
+	Config  Config
+	Metrics *models.DiscordMetrics
+	Tracker *tracker.Tracker
+}
+
+func NewRewarder(config Config, metrics *models.DiscordMetrics, t *tracker.Tracker) *Rewarder {
+	return &Rewarder{
+		Config:  config,
+		Metrics: metrics,
+		Tracker: t,
+	}
+}
+
+// Start checks the roles a minute after starting, once the members are loaded, and then on every interval
+// until the context is done. It returns right away when no reward role is set.
+func (r *Rewarder) Start(ctx context.Context, s *discordgo.Session) {
+	if r.Config.TalkerRoleID == "" && r.Config.RegularRoleID == "" {
+		return
+	}
+	if r.Config.DryRun {
+		log.Println("Role rewards are in dry run mode, changes are only logged")
+	}
+
+	next := time.After(time.Minute)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-next:
+			next = time.After(r.Config.Interval)
+			for _, guildID := range r.Tracker.GuildIDs() {
+				// Members missing from the tracker would never get their role removed
+				if !r.Tracker.MembersLoaded(guildID) {
+					continue
+				}
+				err := r.Run(s, guildID)
+				if err != nil {
+					log.Printf("error rewarding roles in guild %s: %v", guildID, err)
+				}
+			}
+		}
+	}
+}
+
+// Run applies the role changes of the guild, or only logs them in dry run mode
+func (r *Rewarder) Run(s *discordgo.Session, guildID string) error {
+	changes, err := r.Changes(guildID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if r.Config.DryRun {
+			log.Println("dry run, would", change)
+			continue
+		}
+		if change.Add {
+			err = s.GuildMemberRoleAdd(change.GuildID, change.UserID, change.RoleID)
+		} else {
+			err = s.GuildMemberRoleRemove(change.GuildID, change.UserID, change.RoleID)
+		}
+		if err != nil {
+			log.Printf("error trying to %s: %v", change, err)
+			continue
+		}
+		log.Println("rewards:", change)
+	}
+	return nil
+}
+
+// Changes returns the role changes the stats of the guild call for at the time
+func (r *Rewarder) Changes(guildID string, now time.Time) ([]Change, error) {
+	members := r.Tracker.Members(guildID)
+	var changes []Change
+
+	if r.Config.TalkerRoleID != "" {
+		winner, err := r.Metrics.GetLeaderboard(guildID, LastMonth(now, period.LocationFromEnv()), models.VoiceEvent, "", 1)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching talker of the month: %v", err)
+		}
+		qualified := map[string]bool{}
+		for _, entry := range winner {
+			qualified[entry.UserID] = true
+		}
+		changes = append(changes, Plan(guildID, r.Config.TalkerRoleID, members, qualified)...)
+	}
+
+	if r.Config.RegularRoleID != "" {
+		entries, err := r.Metrics.GetLeaderboard(guildID, period.Range{Start: now.Add(-regularWindow)}, models.VoiceEvent, "", 0)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching regulars: %v", err)
+		}
+		qualified := map[string]bool{}
+		for _, entry := range entries {
+			if entry.Total >= r.Config.RegularTime {
+				qualified[entry.UserID] = true
+			}
+		}
+		changes = append(changes, Plan(guildID, r.Config.RegularRoleID, members, qualified)...)
+	}
+	return changes, nil
+}
@@ -0,0 +1,91 @@
+package rewards
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/tracker"
+)
+
+func TestPlan(t *testing.T) {
+	const role = "reward"
+	members := []tracker.Member{
+		{UserID: "1", DisplayName: "alice", RoleIDs: []string{"other"}},         // Qualifies, gets the role
+		{UserID: "2", DisplayName: "bob", RoleIDs: []string{"other", role}},     // Doesn't qualify anymore, loses it
+		{UserID: "3", DisplayName: "carol", RoleIDs: []string{role}},            // Qualifies and has it
+		{UserID: "4", DisplayName: "dave"},                                      // Doesn't qualify and doesn't have it
+		{UserID: "5", DisplayName: "erin", RoleIDs: []string{role}},             // Missing from qualified, loses it
+		{UserID: "6", DisplayName: "frank", RoleIDs: []string{"other", "more"}}, // Missing from qualified without it
+	}
+
+	tests := []struct {
+		name      string
+		members   []tracker.Member
+		qualified map[string]bool
+		want      []string
+	}{
+		{
+			name:      "add and remove",
+			members:   members,
+			qualified: map[string]bool{"1": true, "2": false, "3": true, "4": false, "7": true},
+			want:      []string{"add role reward to alice (1) in guild g", "remove role reward from bob (2) in guild g", "remove role reward from erin (5) in guild g"},
+		},
+		{
+			name:      "nobody qualifies",
+			members:   members,
+			qualified: map[string]bool{},
+			want:      []string{"remove role reward from bob (2) in guild g", "remove role reward from carol (3) in guild g", "remove role reward from erin (5) in guild g"},
+		},
+		{
+			name:      "nothing to change",
+			members:   members,
+			qualified: map[string]bool{"2": true, "3": true, "5": true},
+			want:      nil,
+		},
+		{
+			name:      "no members",
+			qualified: map[string]bool{"1": true},
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, change := range Plan("g", role, tt.members, tt.qualified) {
+			got = append(got, change.String())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLastMonth(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		now   time.Time
+		loc   *time.Location
+		start time.Time
+		stop  time.Time
+	}{
+		{time.Date(2024, time.March, 15, 12, 0, 0, 0, newYork), newYork, time.Date(2024, time.February, 1, 0, 0, 0, 0, newYork), time.Date(2024, time.March, 1, 0, 0, 0, 0, newYork)},
+		{time.Date(2024, time.January, 1, 0, 30, 0, 0, newYork), newYork, time.Date(2023, time.December, 1, 0, 0, 0, 0, newYork), time.Date(2024, time.January, 1, 0, 0, 0, 0, newYork)},
+		// Already March in UTC but still February in New York
+		{time.Date(2024, time.March, 1, 3, 0, 0, 0, time.UTC), newYork, time.Date(2024, time.January, 1, 0, 0, 0, 0, newYork), time.Date(2024, time.February, 1, 0, 0, 0, 0, newYork)},
+		{time.Date(2024, time.March, 1, 3, 0, 0, 0, time.UTC), time.UTC, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		// The 31st has no month before it with as many days
+		{time.Date(2024, time.March, 31, 12, 0, 0, 0, newYork), newYork, time.Date(2024, time.February, 1, 0, 0, 0, 0, newYork), time.Date(2024, time.March, 1, 0, 0, 0, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		r := LastMonth(tt.now, tt.loc)
+		if !r.Start.Equal(tt.start) || !r.Stop.Equal(tt.stop) {
+			t.Errorf("LastMonth(%v, %s) = %v to %v, want %v to %v", tt.now, tt.loc, r.Start, r.Stop, tt.start, tt.stop)
+		}
+	}
+}
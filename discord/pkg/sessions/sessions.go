@@ -0,0 +1,274 @@
+// Package sessions rebuilds voice sessions from the join/leave and on/off events stored in voice_events.
+package sessions
+
+import (
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+)
+
+const (
+	voiceEvent = "voice"
+
+	DefaultMaxSession = 12 * time.Hour
+	DefaultMergeGap   = 2 * time.Minute
+)
+
+// Event is a stored voice event, State is true for joins and for media being turned on
+type Event struct {
+	Time        time.Time
+	UserID      string
+	Username    string
+	DisplayName string
+	ChannelID   string
+	ChannelName string
+	EventType   string
+	State       bool
+	RoleIDs     []string
+	HasRoles    bool
+}
+
+// Session is a span of time a user spent in a channel, or streaming, on webcam, muted or deafened in it
+type Session struct {
+	UserID      string
+	Username    string
+	DisplayName string
+	ChannelID   string
+	ChannelName string
+	EventType   string
+	RoleIDs     []string // Roles of the user when the session started
+	HasRoles    bool
+	Start       time.Time
+	End         time.Time
+	Ongoing     bool // Still open, End is the time the sessions were rebuilt at
+	Capped      bool // The closing event is missing and the session was cut at MaxSession
+}
+
+func (s Session) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+type Options struct {
+	// MaxSession caps sessions whose closing event is missing, it's also how far back
+	// events must be read to find sessions already open when a range starts
+	MaxSession time.Duration
+	// MergeGap merges sessions in the same channel separated by a disconnect shorter than it
+	MergeGap time.Duration
+	// IgnoreChannel reports whether time in the channel of the event doesn't count,
+	// moving into an ignored channel ends the session like leaving
+	IgnoreChannel func(e Event) bool
+	// Now is when open sessions end, the current time when zero
+	Now time.Time
+}
+
+// OptionsFromEnv reads STATS_MAX_SESSION and STATS_SESSION_MERGE_GAP, falling back to the defaults
+func OptionsFromEnv() Options {
+	return Options{
+		MaxSession: durationFromEnv("STATS_MAX_SESSION", DefaultMaxSession),
+		MergeGap:   durationFromEnv("STATS_SESSION_MERGE_GAP", DefaultMergeGap),
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s is not a valid duration: %v", key, err)
+	}
+	return d
+}
+
+// userState is what is open for a user while replaying their events
+type userState struct {
+	voice *Session
+	media map[string]*Session // Streaming, webcam, mute and deafen sessions by event type
+}
+
+// Reconstruct replays the events in time order and returns the sessions sorted by start time.
+//   - Sessions still open at the end are ongoing, or capped when older than MaxSession
+//   - A join while already in a channel closes the previous session at the join, a missed leave never loses time
+//   - A leave or an off event without its opening event is dropped as its start is unknown
+//   - Leaving voice closes the media sessions of the user as Discord doesn't send their off events,
+//     media still on after a channel switch is logged again with the join
+//   - Moving into an ignored channel closes the session, moving out of it opens a new one
+//   - Short disconnects from a channel are merged into a single session
+func Reconstruct(events []Event, opts Options) []Session {
+	if opts.MaxSession <= 0 {
+		opts.MaxSession = DefaultMaxSession
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	sorted := make([]Event, len(events))
+	copy(sorted, events)
+	// On a channel switch the leave, the join and the media still on in the new channel share the timestamp,
+	// they're replayed in that order so the join doesn't close the media it comes with
+	order := func(e Event) int {
+		switch {
+		case !e.State:
+			return 0
+		case e.EventType == voiceEvent:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].Time.Equal(sorted[j].Time) {
+			return sorted[i].Time.Before(sorted[j].Time)
+		}
+		return order(sorted[i]) < order(sorted[j])
+	})
+
+	var closed []Session
+	closeSession := func(s *Session, at time.Time) {
+		if s == nil {
+			return
+		}
+		s.End = at
+		if s.End.Sub(s.Start) > opts.MaxSession {
+			s.End = s.Start.Add(opts.MaxSession)
+			s.Capped = true
+		}
+		if s.End.After(s.Start) {
+			closed = append(closed, *s)
+		}
+	}
+
+	users := map[string]*userState{}
+	for _, e := range sorted {
+		key := e.UserID
+		if key == "" {
+			key = e.Username
+		}
+		u, ok := users[key]
+		if !ok {
+			u = &userState{media: map[string]*Session{}}
+			users[key] = u
+		}
+		ignored := opts.IgnoreChannel != nil && opts.IgnoreChannel(e)
+
+		if e.EventType == voiceEvent {
+			// A leave, a join elsewhere or a repeated join all end what is open
+			closeSession(u.voice, e.Time)
+			u.voice = nil
+			for eventType, media := range u.media {
+				closeSession(media, e.Time)
+				delete(u.media, eventType)
+			}
+			if e.State && !ignored {
+				u.voice = newSession(e)
+			}
+			continue
+		}
+
+		// Media events
+		closeSession(u.media[e.EventType], e.Time)
+		delete(u.media, e.EventType)
+		if e.State && !ignored {
+			u.media[e.EventType] = newSession(e)
+		}
+	}
+
+	for _, u := range users {
+		open := []*Session{u.voice}
+		for _, media := range u.media {
+			open = append(open, media)
+		}
+		for _, s := range open {
+			if s == nil {
+				continue
+			}
+			if now.Sub(s.Start) <= opts.MaxSession {
+				s.Ongoing = true
+			}
+			closeSession(s, now)
+		}
+	}
+
+	return merge(closed, opts.MergeGap)
+}
+
+func newSession(e Event) *Session {
+	return &Session{
+		UserID:      e.UserID,
+		Username:    e.Username,
+		DisplayName: e.DisplayName,
+		ChannelID:   e.ChannelID,
+		ChannelName: e.ChannelName,
+		EventType:   e.EventType,
+		RoleIDs:     e.RoleIDs,
+		HasRoles:    e.HasRoles,
+		Start:       e.Time,
+	}
+}
+
+// merge joins sessions of the same user, type and channel separated by less than the gap
+func merge(sessions []Session, gap time.Duration) []Session {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	if gap <= 0 {
+		return sessions
+	}
+
+	type key struct{ user, eventType, channelID string }
+	last := map[key]int{}
+	merged := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		k := key{s.UserID + "|" + s.Username, s.EventType, s.ChannelID}
+		if i, ok := last[k]; ok && !merged[i].Ongoing && s.Start.Sub(merged[i].End) <= gap {
+			if s.End.After(merged[i].End) {
+				merged[i].End = s.End
+				merged[i].Ongoing = s.Ongoing
+				merged[i].Capped = s.Capped
+			}
+			continue
+		}
+		last[k] = len(merged)
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// Clip cuts the sessions to the range and drops the ones outside of it
+func Clip(sessions []Session, r period.Range) []Session {
+	clipped := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		start, end, ok := r.Clip(s.Start, s.End)
+		if !ok {
+			continue
+		}
+		s.Start, s.End = start, end
+		clipped = append(clipped, s)
+	}
+	return clipped
+}
+
+// Filter returns the sessions of the given event type
+func Filter(sessions []Session, eventType string) []Session {
+	filtered := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		if s.EventType == eventType {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// Total sums the duration of the sessions
+func Total(sessions []Session) time.Duration {
+	var total time.Duration
+	for _, s := range sessions {
+		total += s.Duration()
+	}
+	return total
+}
@@ -0,0 +1,209 @@
+package sessions
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+)
+
+var t0 = time.Date(2024, time.June, 1, 20, 0, 0, 0, time.UTC)
+
+// at is t0 plus the minutes
+func at(minutes int) time.Time {
+	return t0.Add(time.Duration(minutes) * time.Minute)
+}
+
+func event(minutes int, channelID, eventType string, state bool) Event {
+	return Event{Time: at(minutes), UserID: "1", Username: "alice", ChannelID: channelID, ChannelName: "#" + channelID, EventType: eventType, State: state}
+}
+
+// describe is a session in a form that's easy to compare, times in minutes after t0
+func describe(s Session) string {
+	d := fmt.Sprintf("%s %s %v-%v", s.EventType, s.ChannelID, s.Start.Sub(t0).Minutes(), s.End.Sub(t0).Minutes())
+	if s.Ongoing {
+		d += " ongoing"
+	}
+	if s.Capped {
+		d += " capped"
+	}
+	return d
+}
+
+func TestReconstruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		opts   Options
+		want   []string
+	}{
+		{
+			name:   "join and leave",
+			events: []Event{event(0, "a", "voice", true), event(30, "a", "voice", false)},
+			want:   []string{"voice a 0-30"},
+		},
+		{
+			name:   "missed leave",
+			events: []Event{event(0, "a", "voice", true), event(30, "b", "voice", true), event(60, "b", "voice", false)},
+			want:   []string{"voice a 0-30", "voice b 30-60"},
+		},
+		{
+			name:   "missed leave in the same channel",
+			events: []Event{event(0, "a", "voice", true), event(30, "a", "voice", true), event(60, "a", "voice", false)},
+			opts:   Options{MergeGap: -1},
+			want:   []string{"voice a 0-30", "voice a 30-60"},
+		},
+		{
+			name:   "leave without a join",
+			events: []Event{event(10, "a", "voice", false), event(20, "a", "streaming", false)},
+			want:   nil,
+		},
+		{
+			name:   "capped at the max session",
+			events: []Event{event(0, "a", "voice", true), event(20*60, "a", "voice", false)},
+			opts:   Options{MaxSession: 12 * time.Hour},
+			want:   []string{"voice a 0-720 capped"},
+		},
+		{
+			name:   "open longer than the max session",
+			events: []Event{event(0, "a", "voice", true)},
+			opts:   Options{MaxSession: 12 * time.Hour, Now: at(20 * 60)},
+			want:   []string{"voice a 0-720 capped"},
+		},
+		{
+			name:   "ongoing at now",
+			events: []Event{event(0, "a", "voice", true), event(10, "a", "webcam", true)},
+			opts:   Options{Now: at(45)},
+			want:   []string{"voice a 0-45 ongoing", "webcam a 10-45 ongoing"},
+		},
+		{
+			name: "into and out of an ignored channel",
+			events: []Event{
+				event(0, "a", "voice", true),
+				event(30, "a", "voice", false), event(30, "afk", "voice", true),
+				event(90, "afk", "voice", false), event(90, "a", "voice", true),
+				event(120, "a", "voice", false),
+			},
+			opts: Options{IgnoreChannel: func(e Event) bool { return e.ChannelID == "afk" }},
+			want: []string{"voice a 0-30", "voice a 90-120"},
+		},
+		{
+			name: "merged within the gap",
+			events: []Event{
+				event(0, "a", "voice", true), event(30, "a", "voice", false),
+				event(31, "a", "voice", true), event(60, "a", "voice", false),
+			},
+			opts: Options{MergeGap: 2 * time.Minute},
+			want: []string{"voice a 0-60"},
+		},
+		{
+			name: "not merged past the gap",
+			events: []Event{
+				event(0, "a", "voice", true), event(30, "a", "voice", false),
+				event(33, "a", "voice", true), event(60, "a", "voice", false),
+			},
+			opts: Options{MergeGap: 2 * time.Minute},
+			want: []string{"voice a 0-30", "voice a 33-60"},
+		},
+		{
+			name: "not merged across channels",
+			events: []Event{
+				event(0, "a", "voice", true), event(30, "a", "voice", false),
+				event(31, "b", "voice", true), event(60, "b", "voice", false),
+			},
+			opts: Options{MergeGap: 2 * time.Minute},
+			want: []string{"voice a 0-30", "voice b 31-60"},
+		},
+		{
+			name: "leave and join at the same time",
+			events: []Event{
+				event(0, "a", "voice", true),
+				// Stored in the opposite order, the leave is still replayed first
+				event(30, "b", "voice", true), event(30, "a", "voice", false),
+				event(60, "b", "voice", false),
+			},
+			want: []string{"voice a 0-30", "voice b 30-60"},
+		},
+		{
+			name: "leaving closes the media",
+			events: []Event{
+				event(0, "a", "voice", true), event(10, "a", "streaming", true), event(15, "a", "mute", true),
+				event(40, "a", "voice", false),
+			},
+			want: []string{"voice a 0-40", "streaming a 10-40", "mute a 15-40"},
+		},
+		{
+			name: "media carried over a channel switch",
+			events: []Event{
+				event(0, "a", "voice", true), event(10, "a", "streaming", true), event(15, "a", "mute", true),
+				// The media still on is logged with the join, stored in any order
+				event(30, "b", "streaming", true), event(30, "b", "mute", true),
+				event(30, "b", "voice", true), event(30, "a", "voice", false),
+				event(50, "b", "mute", false),
+				event(60, "b", "voice", false),
+			},
+			want: []string{
+				"voice a 0-30", "streaming a 10-30", "mute a 15-30",
+				"mute b 30-50", "voice b 30-60", "streaming b 30-60",
+			},
+		},
+		{
+			name: "media turned off with the switch",
+			events: []Event{
+				event(0, "a", "voice", true), event(10, "a", "webcam", true),
+				event(30, "a", "voice", false), event(30, "b", "voice", true),
+				event(60, "b", "voice", false),
+			},
+			want: []string{"voice a 0-30", "webcam a 10-30", "voice b 30-60"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			if opts.Now.IsZero() {
+				opts.Now = at(24 * 60)
+			}
+			var got []string
+			for _, s := range Reconstruct(tt.events, opts) {
+				got = append(got, describe(s))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClip(t *testing.T) {
+	all := []Session{
+		{EventType: "voice", ChannelID: "a", Start: at(0), End: at(30)},
+		{EventType: "voice", ChannelID: "b", Start: at(20), End: at(80)},
+		{EventType: "voice", ChannelID: "c", Start: at(50), End: at(70)},
+		{EventType: "voice", ChannelID: "d", Start: at(90), End: at(120)},
+		{EventType: "voice", ChannelID: "e", Start: at(100), End: at(130)},
+	}
+
+	tests := []struct {
+		name string
+		r    period.Range
+		want []string
+	}{
+		{"open range", period.Range{}, []string{"voice a 0-30", "voice b 20-80", "voice c 50-70", "voice d 90-120", "voice e 100-130"}},
+		{"start edge", period.Range{Start: at(30)}, []string{"voice b 30-80", "voice c 50-70", "voice d 90-120", "voice e 100-130"}},
+		{"stop edge", period.Range{Stop: at(90)}, []string{"voice a 0-30", "voice b 20-80", "voice c 50-70"}},
+		{"both edges", period.Range{Start: at(25), Stop: at(110)}, []string{"voice a 25-30", "voice b 25-80", "voice c 50-70", "voice d 90-110", "voice e 100-110"}},
+		{"empty range", period.Range{Start: at(200), Stop: at(300)}, nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, s := range Clip(all, tt.r) {
+			got = append(got, describe(s))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
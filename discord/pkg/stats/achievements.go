@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+)
+
+// AchievementWindow covers the longest run of days an achievement needs, a 30 day streak, with some slack
+const AchievementWindow = 35 * 24 * time.Hour
+
+// CheckAchievements stores the achievements unlocked in the range that weren't stored yet and returns them to be announced.
+// The first check of a guild stores the achievements of the whole history without returning them, so old ones aren't
+// announced. A range shorter than the history can miss achievements counted over a longer time, such as the first in
+// ones, a check of the whole history now and then catches them.
+func (s *Stats) CheckAchievements(r period.Range) ([]analytics.Unlock, error) {
+	backfilled, err := s.Metrics.IsAchievementsBackfilled(s.GuildID)
+	if err != nil {
+		return nil, err
+	}
+	if !backfilled {
+		r = period.Range{}
+	}
+
+	stored, err := s.Metrics.GetAchievements(s.GuildID, "")
+	if err != nil {
+		return nil, err
+	}
+	unlocks, err := s.Metrics.GetAchievementUnlocks(s.GuildID, r, s.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, unlock := range stored {
+		known[unlock.UserID+"/"+unlock.ID] = true
+	}
+	var unlocked []analytics.Unlock
+	for _, unlock := range unlocks {
+		// Events logged before users were tracked by ID can't be told apart
+		if unlock.UserID == "" || known[unlock.UserID+"/"+unlock.ID] {
+			continue
+		}
+		unlocked = append(unlocked, unlock)
+	}
+	if len(unlocked) > 0 {
+		err = s.Metrics.LogAchievements(s.GuildID, unlocked)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !backfilled {
+		return nil, s.Metrics.LogAchievementsBackfill(s.GuildID)
+	}
+	return unlocked, nil
+}
+
+// GetBadges returns the achievements the user given by ID unlocked, oldest first
+func (s *Stats) GetBadges(userID string) ([]analytics.Unlock, error) {
+	return s.Metrics.GetAchievements(s.GuildID, userID)
+}
+
+// FormatBadges lists the unlocked achievements with their date, and the ones still locked
+func FormatBadges(unlocks []analytics.Unlock, loc *time.Location) string {
+	unlocked := map[string]analytics.Unlock{}
+	for _, unlock := range unlocks {
+		unlocked[unlock.ID] = unlock
+	}
+
+	var message strings.Builder
+	var locked []string
+	for _, achievement := range analytics.Achievements {
+		unlock, ok := unlocked[achievement.ID]
+		if !ok {
+			locked = append(locked, fmt.Sprintf("🔒 %s: %s", achievement.Name, achievement.Description))
+			continue
+		}
+		message.WriteString(fmt.Sprintf("%s %s: %s, on %s\n", achievement.Emoji, achievement.Name, achievement.Description, unlock.At.In(loc).Format("02 Jan 2006")))
+	}
+	if message.Len() == 0 {
+		message.WriteString("No badges yet\n")
+	}
+	if len(locked) > 0 {
+		message.WriteString("\n" + strings.Join(locked, "\n"))
+	}
+	return strings.TrimSuffix(message.String(), "\n")
+}
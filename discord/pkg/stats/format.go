@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+)
+
+// FormatDuration formats a duration as hours and minutes, as in 12h:5m
+func FormatDuration(d time.Duration) string {
+	return fmt.Sprintf("%dh:%dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// UsualComparison compares the users on call with the average for the same hour of the week
+func (s Status) UsualComparison(now time.Time) string {
+	when := now.Format("Monday 15:00")
+	current := float64(s.OncallCount)
+
+	switch {
+	case current > s.Usual*1.25 && current-s.Usual >= 1:
+		return fmt.Sprintf("🔥 Busier than usual for a %s (%.1f on average)", when, s.Usual)
+	case current < s.Usual*0.75 && s.Usual-current >= 1:
+		return fmt.Sprintf("🧊 Quieter than usual for a %s (%.1f on average)", when, s.Usual)
+	default:
+		return fmt.Sprintf("👌 About as busy as usual for a %s (%.1f on average)", when, s.Usual)
+	}
+}
+
+// MediaIcons returns the icons of what the member is doing in the channel, each one after a space
+func MediaIcons(member models.ChannelMember) string {
+	icons := ""
+	if member.Streaming {
+		icons += " 📺"
+	}
+	if member.Webcam {
+		icons += " 📷"
+	}
+	if member.Deaf {
+		icons += " 🙉"
+	} else if member.Mute {
+		icons += " 🔇"
+	}
+	return icons
+}
+
+// FormatChannelMember is a member of a voice channel with their media icons and how long they've been in
+func FormatChannelMember(member models.ChannelMember, now time.Time) string {
+	return fmt.Sprintf("%s%s · %s", member.DisplayName, MediaIcons(member), FormatDuration(now.Sub(member.JoinedAt)))
+}
+
+func LeaderboardTitle(eventType, channel string, r period.Range) string {
+	var title strings.Builder
+	switch eventType {
+	case models.StreamEvent:
+		title.WriteString("📺 Streaming leaderboard")
+	case models.WebcamEvent:
+		title.WriteString("📸 Webcam leaderboard")
+	default:
+		title.WriteString("🏆 Voice time leaderboard")
+	}
+	if channel != "" {
+		title.WriteString(fmt.Sprintf(" in %s", channel))
+	}
+	title.WriteString(fmt.Sprintf(" %s", r.Label))
+	return title.String()
+}
+
+// FormatLeaderboard ranks the entries one per line, with medals for the first three
+func FormatLeaderboard(entries []analytics.LeaderboardEntry) string {
+	if len(entries) == 0 {
+		return "Nobody yet, be the first!"
+	}
+
+	var message strings.Builder
+	medals := []string{"🥇", "🥈", "🥉"}
+	for i, entry := range entries {
+		rank := fmt.Sprintf("%d.", i+1)
+		if i < len(medals) {
+			rank = medals[i]
+		}
+		message.WriteString(fmt.Sprintf("%s %s: %s\n", rank, entry.DisplayName, FormatDuration(entry.Total)))
+	}
+	return message.String()
+}
+
+// HistoryIcons returns the icons of what the user did during a session, each one after a space
+func HistoryIcons(entry analytics.HistoryEntry) string {
+	icons := ""
+	if entry.Streamed {
+		icons += " 📺"
+	}
+	if entry.Webcam {
+		icons += " 📷"
+	}
+	return icons
+}
@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
+	"github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+const recapTopTalkers = 5
+
+// Recap is a weekly or monthly report of the voice activity, compared with the period before
+type Recap struct {
+	Report        string
+	Range         period.Range
+	PreviousRange period.Range
+	Current       analytics.Recap
+	Previous      analytics.Recap
+	Peak          int64 // Most users on call at once
+	LongestRecord bool  // The longest session is the longest ever
+	PeakRecord    bool  // Never were so many users on call at once
+}
+
+// ReportRanges returns the last full week or month before the time, the period before it and the key
+// the report is posted once for, such as 2024-W05 or 2024-01. Periods are in the time zone of the time.
+func ReportRanges(report string, now time.Time) (current, previous period.Range, key string) {
+	if report == models.MonthlyReport {
+		month := period.Month(now)
+		current = period.Month(month.Start.AddDate(0, -1, 0))
+		previous = period.Month(month.Start.AddDate(0, -2, 0))
+		current.Label, previous.Label = "last month", "the month before"
+		return current, previous, current.Start.Format("2006-01")
+	}
+	current = period.Week(now.AddDate(0, 0, -7))
+	previous = period.Week(now.AddDate(0, 0, -14))
+	current.Label, previous.Label = "last week", "the week before"
+	year, week := current.Start.ISOWeek()
+	return current, previous, fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// GetRecap builds the report of the last full week or month before the time, nights are split in the time zone of the time
+func (s *Stats) GetRecap(report string, now time.Time) (Recap, error) {
+	current, previous, _ := ReportRanges(report, now)
+	recap := Recap{Report: report, Range: current, PreviousRange: previous}
+
+	voiceRecap := func(r period.Range) ([]sessions.Session, analytics.Recap, error) {
+		voice, err := s.Metrics.GetSessions(s.GuildID, r, flux.Eq(models.EventTypeKey, models.VoiceEvent))
+		if err != nil {
+			return nil, analytics.Recap{}, err
+		}
+		voice = sessions.Filter(voice, models.VoiceEvent)
+		return voice, analytics.NewRecap(voice, now.Location(), recapTopTalkers), nil
+	}
+
+	var err error
+	if _, recap.Current, err = voiceRecap(current); err != nil {
+		return Recap{}, err
+	}
+	if _, recap.Previous, err = voiceRecap(previous); err != nil {
+		return Recap{}, err
+	}
+
+	// Records are only new when there's history to beat
+	before, _, err := voiceRecap(period.Range{Stop: current.Start})
+	if err != nil {
+		return Recap{}, err
+	}
+	var longestBefore time.Duration
+	for _, session := range before {
+		longestBefore = max(longestBefore, session.Duration())
+	}
+	recap.LongestRecord = longestBefore > 0 && recap.Current.Longest.Duration() > longestBefore
+
+	peaks, err := s.Metrics.GetUsersCountSeries(models.OncallUsersMeasurement, s.GuildID, current, 24*time.Hour, "max")
+	if err != nil {
+		return Recap{}, err
+	}
+	peaksBefore, err := s.Metrics.GetUsersCountSeries(models.OncallUsersMeasurement, s.GuildID, period.Range{Stop: current.Start}, 24*time.Hour, "max")
+	if err != nil {
+		return Recap{}, err
+	}
+	recap.Peak = int64(maxValue(peaks))
+	peakBefore := int64(maxValue(peaksBefore))
+	recap.PeakRecord = peakBefore > 0 && recap.Peak > peakBefore
+	return recap, nil
+}
+
+func maxValue(points []analytics.Point) float64 {
+	var highest float64
+	for _, point := range points {
+		highest = max(highest, point.Value)
+	}
+	return highest
+}
+
+// FormatRecap formats the recap as a message, with the trend against the period before
+func FormatRecap(recap Recap) string {
+	var message strings.Builder
+	title := "📅 Weekly recap"
+	if recap.Report == models.MonthlyReport {
+		title = "🗓️ Monthly recap"
+	}
+	message.WriteString(fmt.Sprintf("%s, %s to %s\n\n", title,
+		recap.Range.Start.Format("Mon 02 Jan"), recap.Range.Stop.AddDate(0, 0, -1).Format("Mon 02 Jan")))
+
+	if recap.Current.Sessions == 0 {
+		message.WriteString("🦗 Nobody was on call " + recap.Range.Label)
+		return message.String()
+	}
+
+	message.WriteString(fmt.Sprintf("🎙️ %s on call in %d sessions by %d people\n",
+		FormatDuration(recap.Current.Total), recap.Current.Sessions, recap.Current.People))
+	message.WriteString(trend(recap.Current.Total, recap.Previous.Total, recap.PreviousRange.Label))
+	message.WriteString("\n\n🏆 Top talkers\n")
+	message.WriteString(FormatLeaderboard(recap.Current.Top))
+	message.WriteString(fmt.Sprintf("\n🌙 Busiest night: %s with %s on call\n",
+		recap.Current.BusiestNight.Format("Monday 02 Jan"), FormatDuration(recap.Current.BusiestNightTotal)))
+	message.WriteString(fmt.Sprintf("👥 Up to %d people on call at once\n", recap.Peak))
+
+	longest := recap.Current.Longest
+	if recap.LongestRecord || recap.PeakRecord {
+		message.WriteString("\n🔥 New records\n")
+	}
+	if recap.LongestRecord {
+		message.WriteString(fmt.Sprintf("Longest session ever: %s with %s in %s\n", longest.DisplayName, FormatDuration(longest.Duration()), longest.ChannelName))
+	}
+	if recap.PeakRecord {
+		message.WriteString(fmt.Sprintf("Most people on call at once ever: %d\n", recap.Peak))
+	}
+	return strings.TrimSuffix(message.String(), "\n")
+}
+
+// trend compares the voice time with the period before
+func trend(current, previous time.Duration, before string) string {
+	if previous == 0 {
+		return fmt.Sprintf("✨ Nobody was on call %s", before)
+	}
+	change := (current.Hours() - previous.Hours()) / previous.Hours() * 100
+	switch {
+	case change >= 1:
+		return fmt.Sprintf("📈 %.0f%% more than %s (%s)", change, before, FormatDuration(previous))
+	case change <= -1:
+		return fmt.Sprintf("📉 %.0f%% less than %s (%s)", -change, before, FormatDuration(previous))
+	default:
+		return fmt.Sprintf("👌 About the same as %s (%s)", before, FormatDuration(previous))
+	}
+}
@@ -0,0 +1,196 @@
+// Package stats answers the questions both bots ask about a guild, so the Discord commands and
+// the Telegram commands show the same numbers.
+package stats
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/identity"
+	"github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/sessions"
+)
+
+type Stats struct {
+	Metrics  *models.DiscordMetrics
+	GuildID  string
+	Location *time.Location
+}
+
+func NewStats(metrics *models.DiscordMetrics, guildID string) *Stats {
+	return &Stats{
+		Metrics:  metrics,
+		GuildID:  guildID,
+		Location: period.LocationFromEnv(),
+	}
+}
+
+// NewStatsFromEnv returns the stats of the guild given by DISCORD_GUILD_ID
+func NewStatsFromEnv() *Stats {
+	guildID, ok := os.LookupEnv("DISCORD_GUILD_ID")
+	if !ok {
+		log.Fatal("DISCORD_GUILD_ID env var is required")
+	}
+
+	return NewStats(models.NewAuthenticatedDiscordMetricsClient(), guildID)
+}
+
+type Status struct {
+	GuildName   string
+	OncallCount int64
+	Oncall      []string
+	OnlineCount int64
+	Online      []string
+	Channels    []models.ChannelOccupancy // Empty when the channel members aren't logged
+	Last24h     []analytics.Point         // Hourly peak of on call users, empty when it can't be fetched
+	Usual       float64                   // Average on call users for the current hour of the week over the last 8 weeks
+	HasUsual    bool                      // False when the heatmap can't be fetched, Usual isn't known then
+}
+
+// GetStatus returns who is on call and online. The channels and the trend are nice to have,
+// the status is returned without them if they can't be fetched.
+func (s *Stats) GetStatus() (Status, error) {
+	var status Status
+	var err error
+	status.GuildName, status.OncallCount, status.Oncall, err = s.Metrics.GetOncallUsers(s.GuildID)
+	if err != nil {
+		return Status{}, err
+	}
+	_, status.OnlineCount, status.Online, err = s.Metrics.GetOnlineUsers(s.GuildID)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status.Channels, err = s.Metrics.GetChannelOccupancy(s.GuildID)
+	if err != nil {
+		log.Println("error fetching channel occupancy:", err)
+	}
+
+	now := time.Now()
+	status.Last24h, err = s.Metrics.GetUsersCountSeries(models.OncallUsersMeasurement, s.GuildID, period.Range{Start: now.Add(-24 * time.Hour)}, time.Hour, "max")
+	if err != nil {
+		log.Println("error fetching on call trend:", err)
+	}
+	heatmap, err := s.Metrics.GetOncallHeatmap(s.GuildID, 8, s.Location)
+	if err != nil {
+		log.Println("error fetching on call heatmap:", err)
+	} else {
+		status.Usual = heatmap.Usual(now.In(s.Location))
+		status.HasUsual = true
+	}
+	return status, nil
+}
+
+// ResolveUser finds the Discord user given by username, display name, nickname, mention or ID
+func (s *Stats) ResolveUser(query string) (identity.User, error) {
+	return s.Metrics.ResolveUser(s.GuildID, query)
+}
+
+func (s *Stats) GetUserVoiceTime(userID string, r period.Range) (time.Duration, error) {
+	return s.Metrics.GetUserVoiceTime(userID, s.GuildID, r)
+}
+
+func (s *Stats) GetRoleVoiceTime(r period.Range) ([]models.RoleVoiceTime, error) {
+	return s.Metrics.GetRoleVoiceTime(s.GuildID, r)
+}
+
+func (s *Stats) GetLeaderboard(r period.Range, eventType, channel string, n int) ([]analytics.LeaderboardEntry, error) {
+	return s.Metrics.GetLeaderboard(s.GuildID, r, eventType, channel, n)
+}
+
+func (s *Stats) GetChannelStats(channel string, r period.Range) (analytics.ChannelStats, error) {
+	return s.Metrics.GetChannelStats(s.GuildID, channel, r, s.Location)
+}
+
+func (s *Stats) GetCoPresence(r period.Range) ([]analytics.Pair, error) {
+	return s.Metrics.GetCoPresence(s.GuildID, r)
+}
+
+// GetHeatmap returns the average on call users per hour of the week, or the presence of the user when a user ID is given
+func (s *Stats) GetHeatmap(userID string, weeks int) (analytics.HourOfWeek, error) {
+	if userID == "" {
+		return s.Metrics.GetOncallHeatmap(s.GuildID, weeks, s.Location)
+	}
+	return s.Metrics.GetUserHeatmap(s.GuildID, userID, weeks, s.Location)
+}
+
+// GetUsersCountSeries returns the on call and online users series in the range, aggregated by their max in each window
+func (s *Stats) GetUsersCountSeries(r period.Range, every time.Duration) (oncall []analytics.Point, online []analytics.Point, error error) {
+	oncall, err := s.Metrics.GetUsersCountSeries(models.OncallUsersMeasurement, s.GuildID, r, every, "max")
+	if err != nil {
+		return nil, nil, err
+	}
+	online, err = s.Metrics.GetUsersCountSeries(models.OnlineUsersMeasurement, s.GuildID, r, every, "max")
+	if err != nil {
+		return nil, nil, err
+	}
+	return oncall, online, nil
+}
+
+// GetLastSession returns the latest voice session of the user, given by ID
+func (s *Stats) GetLastSession(userID string) (sessions.Session, bool, error) {
+	return s.Metrics.GetLastSession(s.GuildID, userID)
+}
+
+// GetUserHistory returns the latest n voice sessions of the user, given by ID, latest first
+func (s *Stats) GetUserHistory(userID string, n int) ([]analytics.HistoryEntry, error) {
+	return s.Metrics.GetUserHistory(s.GuildID, userID, n)
+}
+
+// GetOccupancyAt returns who was in each voice channel at the time, rebuilt from the voice events
+func (s *Stats) GetOccupancyAt(t time.Time) ([]models.ChannelOccupancy, error) {
+	return s.Metrics.GetOccupancyAt(s.GuildID, t)
+}
+
+// ChatLocation returns the time zone of the Telegram chat, the stats time zone when the chat didn't set one
+func (s *Stats) ChatLocation(chatID int64) *time.Location {
+	loc, ok, err := s.Metrics.GetChatTimezone(chatID)
+	if err != nil {
+		log.Println("error fetching chat time zone:", err)
+	}
+	if !ok {
+		return s.Location
+	}
+	return loc
+}
+
+func (s *Stats) SetChatTimezone(chatID int64, loc *time.Location) error {
+	return s.Metrics.SetChatTimezone(chatID, loc)
+}
+
+func (s *Stats) SetReportSchedule(chatID int64, report, at string) error {
+	return s.Metrics.SetReportSchedule(chatID, report, at)
+}
+
+func (s *Stats) GetReportSchedules() ([]models.ReportSchedule, error) {
+	return s.Metrics.GetReportSchedules()
+}
+
+func (s *Stats) IsReportPosted(chatID int64, report, reportPeriod string) (bool, error) {
+	return s.Metrics.IsReportPosted(chatID, report, reportPeriod)
+}
+
+func (s *Stats) LogReportPost(chatID int64, report, reportPeriod string) error {
+	return s.Metrics.LogReportPost(chatID, report, reportPeriod)
+}
+
+// CreateLinkCode returns a one time code the Telegram user confirms from Discord to link their accounts
+func (s *Stats) CreateLinkCode(account models.TelegramAccount) (string, error) {
+	return s.Metrics.CreateLinkCode(account)
+}
+
+func (s *Stats) Unlink(telegramUserID int64) error {
+	return s.Metrics.Unlink(telegramUserID)
+}
+
+// GetAccountLinks returns the Telegram account linked to each Discord user ID
+func (s *Stats) GetAccountLinks() (map[string]models.TelegramAccount, error) {
+	return s.Metrics.GetAccountLinks()
+}
+
+func (s *Stats) GetLinkedDiscordUser(telegramUserID int64) (string, bool, error) {
+	return s.Metrics.GetLinkedDiscordUser(telegramUserID)
+}
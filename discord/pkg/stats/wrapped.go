@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+)
+
+// GetWrapped returns the year in review of the user given by ID, or of the server when the ID is empty
+func (s *Stats) GetWrapped(userID string, year int) (analytics.Wrapped, error) {
+	r := period.Year(time.Date(year, time.January, 1, 0, 0, 0, 0, s.Location))
+	return s.Metrics.GetWrapped(s.GuildID, userID, r, s.Location)
+}
+
+// FormatWrapped formats the year in review as a sequence of messages, who is the user label or empty for the server
+func FormatWrapped(wrapped analytics.Wrapped, who string, year int, loc *time.Location) []string {
+	server := who == ""
+	if server {
+		who = "The server"
+	}
+	if wrapped.Sessions == 0 {
+		return []string{fmt.Sprintf("🦗 %s wasn't on call in %d", who, year)}
+	}
+
+	intro := fmt.Sprintf("🎁 %s Wrapped %d\n\n🎙️ %s on call in %d sessions over %d days",
+		who, year, FormatDuration(wrapped.Total), wrapped.Sessions, wrapped.ActiveDays)
+	if server {
+		intro += fmt.Sprintf(", by %d people", wrapped.People)
+	}
+
+	longest := wrapped.Longest
+	longestBy := ""
+	if server {
+		longestBy = " by " + longest.DisplayName
+	}
+	places := fmt.Sprintf("⏱️ Longest session: %s%s in %s on %s\n\n🏠 Favorite channel: %s with %s",
+		FormatDuration(longest.Duration()), longestBy, longest.ChannelName, longest.Start.In(loc).Format("Mon 02 Jan"),
+		wrapped.FavoriteChannel, FormatDuration(wrapped.FavoriteChannelTotal))
+
+	var people strings.Builder
+	if server {
+		people.WriteString("🏆 Top talkers\n")
+		people.WriteString(FormatLeaderboard(wrapped.Top))
+		if len(wrapped.Duos) > 0 {
+			people.WriteString("\n👯 Inseparable duos\n")
+		}
+		for _, duo := range wrapped.Duos {
+			people.WriteString(fmt.Sprintf("%s & %s: %s\n", duo.A.DisplayName, duo.B.DisplayName, FormatDuration(duo.Overlap)))
+		}
+	} else {
+		people.WriteString("👯 Top call buddies\n")
+		for _, buddy := range wrapped.Buddies {
+			people.WriteString(fmt.Sprintf("%s: %s\n", buddy.DisplayName, FormatDuration(buddy.Overlap)))
+		}
+		if len(wrapped.Buddies) == 0 {
+			people.WriteString("Always on their own\n")
+		}
+	}
+
+	media := fmt.Sprintf("📺 Streaming: %s\n📷 Webcam: %s", FormatDuration(wrapped.Streaming), FormatDuration(wrapped.Webcam))
+
+	streakDays := "days"
+	if wrapped.LongestStreak == 1 {
+		streakDays = "day"
+	}
+	calendar := fmt.Sprintf("📅 Busiest month: %s with %s\n\n🔥 Longest streak: %d %s in a row, until %s",
+		wrapped.BusiestMonth.Format("January"), FormatDuration(wrapped.BusiestMonthTotal),
+		wrapped.LongestStreak, streakDays, wrapped.LongestStreakEnd.Format("Mon 02 Jan"))
+
+	return []string{intro, places, strings.TrimSuffix(people.String(), "\n"), media, calendar}
+}
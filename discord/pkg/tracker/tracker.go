@@ -0,0 +1,534 @@
+package tracker
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/vcaldo/cerverox9/discord/pkg/filters"
+)
+
+type Member struct {
+	UserID      string
+	Username    string
+	GlobalName  string
+	Nick        string
+	DisplayName string // Nick, global name or username, the first one set
+	Bot         bool
+	RoleIDs     []string
+}
+
+type Channel struct {
+	ID       string
+	Name     string
+	ParentID string
+	Voice    bool
+}
+
+type VoiceState struct {
+	ChannelID  string
+	SelfMute   bool
+	SelfDeaf   bool
+	SelfStream bool
+	SelfVideo  bool
+	JoinedAt   time.Time
+}
+
+type VoiceMember struct {
+	Member
+	VoiceState
+	Channel Channel
+}
+
+// Snapshot is who is on call and who is online in a guild, with the filtering rules applied
+type Snapshot struct {
+	GuildID   string
+	GuildName string
+	Oncall    []VoiceMember // Sorted by display name
+	Online    []Member      // Online users that are not on call, sorted by display name
+	Channels  []Channel     // Voice channels that are not ignored, sorted by name
+}
+
+type guild struct {
+	name     string
+	members  map[string]Member
+	channels map[string]Channel
+	voice    map[string]VoiceState
+	online   map[string]bool
+	chunks   int
+	loaded   bool
+}
+
+// Tracker is an in-memory model of the voice channels and presence of every guild,
+// kept up to date from gateway events so nothing has to be fetched from the REST API.
+type Tracker struct {
+	rules   *filters.Rules
+	mu      sync.RWMutex
+	guilds  map[string]*guild
+	dirty   map[string]bool // Guilds changed since the last coalescing tick
+	changes chan string
+}
+
+// changesInterval coalesces the changes of a guild, a burst of presence updates is announced at most once per interval
+const changesInterval = 2 * time.Second
+
+func NewTracker(rules *filters.Rules) *Tracker {
+	return &Tracker{
+		rules:   rules,
+		guilds:  map[string]*guild{},
+		dirty:   map[string]bool{},
+		changes: make(chan string, 100),
+	}
+}
+
+// Register adds the gateway event handlers that keep the tracker up to date.
+// Voice state updates are left to the caller through UpdateVoiceState as it needs the previous state.
+// It also starts announcing the changes of the guilds.
+func (t *Tracker) Register(s *discordgo.Session) {
+	s.AddHandler(t.guildCreate)
+	s.AddHandler(t.guildUpdate)
+	s.AddHandler(t.guildDelete)
+	s.AddHandler(t.guildMembersChunk)
+	s.AddHandler(t.guildMemberAdd)
+	s.AddHandler(t.guildMemberUpdate)
+	s.AddHandler(t.guildMemberRemove)
+	s.AddHandler(t.presenceUpdate)
+	s.AddHandler(t.channelCreate)
+	s.AddHandler(t.channelUpdate)
+	s.AddHandler(t.channelDelete)
+
+	go t.announceChanges()
+}
+
+// Changes receives the ID of a guild whenever the people on call or online change, at most once per changesInterval
+func (t *Tracker) Changes() <-chan string {
+	return t.changes
+}
+
+// GuildIDs returns the IDs of every tracked guild
+func (t *Tracker) GuildIDs() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	guildIDs := make([]string, 0, len(t.guilds))
+	for guildID := range t.guilds {
+		guildIDs = append(guildIDs, guildID)
+	}
+	sort.Strings(guildIDs)
+	return guildIDs
+}
+
+// MembersLoaded reports whether every member chunk of the guild has arrived
+func (t *Tracker) MembersLoaded(guildID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	g, ok := t.guilds[guildID]
+	return ok && g.loaded
+}
+
+// Snapshot returns who is on call and who is online in the guild
+func (t *Tracker) Snapshot(guildID string) (Snapshot, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	g, ok := t.guilds[guildID]
+	if !ok {
+		return Snapshot{}, false
+	}
+	return t.snapshot(guildID, g), true
+}
+
+// Members returns the members of the guild that are not ignored by the filtering rules, sorted by user ID
+func (t *Tracker) Members(guildID string) []Member {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	g, ok := t.guilds[guildID]
+	if !ok {
+		return nil
+	}
+	members := make([]Member, 0, len(g.members))
+	for userID, member := range g.members {
+		if !t.rules.IgnoresUser(g.subject(userID, "")) {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].UserID < members[j].UserID
+	})
+	return members
+}
+
+// Subject describes a member in a channel for the filtering rules
+func (t *Tracker) Subject(guildID, userID, channelID string) filters.Subject {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	g, ok := t.guilds[guildID]
+	if !ok {
+		return filters.Subject{UserID: userID, ChannelID: channelID}
+	}
+	return g.subject(userID, channelID)
+}
+
+// UpdateVoiceState applies a voice state update received at the given time and returns the state the user had before it
+func (t *Tracker) UpdateVoiceState(vsu *discordgo.VoiceStateUpdate, receivedAt time.Time) VoiceState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.guild(vsu.GuildID)
+	if vsu.Member != nil && vsu.Member.User != nil {
+		g.members[vsu.UserID] = newMember(vsu.Member)
+	}
+
+	before := g.voice[vsu.UserID]
+	if vsu.ChannelID == "" {
+		delete(g.voice, vsu.UserID)
+	} else {
+		joinedAt := before.JoinedAt
+		if before.ChannelID != vsu.ChannelID {
+			joinedAt = receivedAt
+		}
+		g.voice[vsu.UserID] = newVoiceState(vsu.VoiceState, joinedAt)
+	}
+	t.changed(vsu.GuildID)
+	return before
+}
+
+func (t *Tracker) guildCreate(s *discordgo.Session, gc *discordgo.GuildCreate) {
+	t.mu.Lock()
+	g := t.guild(gc.ID)
+	g.name = gc.Name
+	for _, member := range gc.Members {
+		g.members[member.User.ID] = newMember(member)
+	}
+	for _, channel := range gc.Channels {
+		g.channels[channel.ID] = newChannel(channel)
+	}
+	// Users already on call when the bot connects are counted from now on,
+	// unless they were already tracked in the same channel before a reconnect
+	now := time.Now()
+	voice := map[string]VoiceState{}
+	for _, vs := range gc.VoiceStates {
+		if vs.ChannelID == "" {
+			continue
+		}
+		joinedAt := now
+		if before, ok := g.voice[vs.UserID]; ok && before.ChannelID == vs.ChannelID {
+			joinedAt = before.JoinedAt
+		}
+		voice[vs.UserID] = newVoiceState(vs, joinedAt)
+	}
+	g.voice = voice
+	g.online = map[string]bool{}
+	for _, presence := range gc.Presences {
+		g.online[presence.User.ID] = presence.Status != discordgo.StatusOffline
+	}
+	g.chunks = 0
+	g.loaded = false
+	t.changed(gc.ID)
+	// The lock is released before the gateway call, other handlers wait on it
+	t.mu.Unlock()
+
+	// Large guilds only send online members on create, request the full member list.
+	// The gateway answers with paginated member chunks, no REST call is needed.
+	err := s.RequestGuildMembers(gc.ID, "", 0, gc.ID, true)
+	if err != nil {
+		log.Printf("error requesting members for guild %s: %v", gc.ID, err)
+	}
+}
+
+func (t *Tracker) guildUpdate(s *discordgo.Session, gu *discordgo.GuildUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.guild(gu.ID).name = gu.Name
+}
+
+func (t *Tracker) guildDelete(s *discordgo.Session, gd *discordgo.GuildDelete) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.guilds, gd.ID)
+}
+
+func (t *Tracker) guildMembersChunk(s *discordgo.Session, gmc *discordgo.GuildMembersChunk) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.guild(gmc.GuildID)
+	for _, member := range gmc.Members {
+		g.members[member.User.ID] = newMember(member)
+	}
+	for _, presence := range gmc.Presences {
+		g.online[presence.User.ID] = presence.Status != discordgo.StatusOffline
+	}
+	g.chunks++
+	if g.chunks >= gmc.ChunkCount && !g.loaded {
+		g.loaded = true
+		log.Printf("Loaded %d members of guild %s in %d chunks", len(g.members), gmc.GuildID, gmc.ChunkCount)
+	}
+	t.changed(gmc.GuildID)
+}
+
+func (t *Tracker) guildMemberAdd(s *discordgo.Session, gma *discordgo.GuildMemberAdd) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.guild(gma.GuildID)
+	g.members[gma.User.ID] = newMember(gma.Member)
+	t.changed(gma.GuildID)
+}
+
+func (t *Tracker) guildMemberUpdate(s *discordgo.Session, gmu *discordgo.GuildMemberUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.guild(gmu.GuildID)
+	g.members[gmu.User.ID] = newMember(gmu.Member)
+	t.changed(gmu.GuildID)
+}
+
+func (t *Tracker) guildMemberRemove(s *discordgo.Session, gmr *discordgo.GuildMemberRemove) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.guild(gmr.GuildID)
+	delete(g.members, gmr.User.ID)
+	delete(g.voice, gmr.User.ID)
+	delete(g.online, gmr.User.ID)
+	t.changed(gmr.GuildID)
+}
+
+func (t *Tracker) presenceUpdate(s *discordgo.Session, pu *discordgo.PresenceUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.guild(pu.GuildID)
+	g.online[pu.User.ID] = pu.Status != discordgo.StatusOffline
+	t.changed(pu.GuildID)
+}
+
+func (t *Tracker) channelCreate(s *discordgo.Session, cc *discordgo.ChannelCreate) {
+	t.updateChannel(cc.Channel)
+}
+
+func (t *Tracker) channelUpdate(s *discordgo.Session, cu *discordgo.ChannelUpdate) {
+	t.updateChannel(cu.Channel)
+}
+
+func (t *Tracker) channelDelete(s *discordgo.Session, cd *discordgo.ChannelDelete) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if cd.GuildID == "" {
+		return
+	}
+	delete(t.guild(cd.GuildID).channels, cd.ID)
+}
+
+func (t *Tracker) updateChannel(channel *discordgo.Channel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Direct messages are not part of any guild
+	if channel.GuildID == "" {
+		return
+	}
+	g := t.guild(channel.GuildID)
+	g.channels[channel.ID] = newChannel(channel)
+	t.changed(channel.GuildID)
+}
+
+// guild returns the tracked guild, creating it when it's the first event seen for it. Callers hold the lock.
+func (t *Tracker) guild(guildID string) *guild {
+	g, ok := t.guilds[guildID]
+	if !ok {
+		g = &guild{
+			members:  map[string]Member{},
+			channels: map[string]Channel{},
+			voice:    map[string]VoiceState{},
+			online:   map[string]bool{},
+		}
+		t.guilds[guildID] = g
+	}
+	return g
+}
+
+// changed marks the guild to be checked for changes on the next tick, so events don't pay for a snapshot. Callers hold the lock.
+func (t *Tracker) changed(guildID string) {
+	t.dirty[guildID] = true
+}
+
+// announceChanges checks the changed guilds on every tick and announces the ones where the people
+// on call or online changed. Snapshots are taken under the read lock, outside of the event handlers.
+func (t *Tracker) announceChanges() {
+	ticker := time.NewTicker(changesInterval)
+	defer ticker.Stop()
+
+	// Signature of the last snapshot announced for each guild, only used by this goroutine
+	announced := map[string]string{}
+	for range ticker.C {
+		t.mu.Lock()
+		dirty := t.dirty
+		t.dirty = map[string]bool{}
+		t.mu.Unlock()
+
+		for guildID := range dirty {
+			snapshot, ok := t.Snapshot(guildID)
+			if !ok {
+				delete(announced, guildID)
+				continue
+			}
+			signature := snapshot.signature()
+			if signature == announced[guildID] {
+				continue
+			}
+			announced[guildID] = signature
+
+			select {
+			case t.changes <- guildID:
+			default:
+				// Pending notifications are enough, the heartbeat catches up anyway
+			}
+		}
+	}
+}
+
+func (t *Tracker) snapshot(guildID string, g *guild) Snapshot {
+	snapshot := Snapshot{
+		GuildID:   guildID,
+		GuildName: g.name,
+		Oncall:    []VoiceMember{},
+		Online:    []Member{},
+		Channels:  []Channel{},
+	}
+
+	for channelID, channel := range g.channels {
+		if channel.Voice && !t.rules.IgnoresChannel(g.subject("", channelID)) {
+			snapshot.Channels = append(snapshot.Channels, channel)
+		}
+	}
+
+	oncall := map[string]bool{}
+	for userID, vs := range g.voice {
+		member, ok := g.members[userID]
+		if !ok {
+			continue
+		}
+		if t.rules.Ignores(g.subject(userID, vs.ChannelID)) {
+			continue
+		}
+		oncall[userID] = true
+		snapshot.Oncall = append(snapshot.Oncall, VoiceMember{
+			Member:     member,
+			VoiceState: vs,
+			Channel:    g.channels[vs.ChannelID],
+		})
+	}
+
+	for userID, online := range g.online {
+		member, ok := g.members[userID]
+		if !online || !ok || oncall[userID] {
+			continue
+		}
+		if t.rules.IgnoresUser(g.subject(userID, "")) {
+			continue
+		}
+		snapshot.Online = append(snapshot.Online, member)
+	}
+
+	sort.Slice(snapshot.Oncall, func(i, j int) bool {
+		return snapshot.Oncall[i].DisplayName < snapshot.Oncall[j].DisplayName
+	})
+	sort.Slice(snapshot.Online, func(i, j int) bool {
+		return snapshot.Online[i].DisplayName < snapshot.Online[j].DisplayName
+	})
+	sort.Slice(snapshot.Channels, func(i, j int) bool {
+		return snapshot.Channels[i].Name < snapshot.Channels[j].Name
+	})
+	return snapshot
+}
+
+// signature identifies who is where and with which media on, ignoring changes that don't alter the counts or lists
+func (s Snapshot) signature() string {
+	var b strings.Builder
+	b.WriteString(s.GuildName)
+	for _, member := range s.Oncall {
+		b.WriteString("|" + member.UserID + "@" + member.ChannelID + "=" + member.DisplayName)
+		fmt.Fprintf(&b, ":%t%t%t%t", member.SelfMute, member.SelfDeaf, member.SelfStream, member.SelfVideo)
+	}
+	b.WriteString("#")
+	for _, member := range s.Online {
+		b.WriteString("|" + member.UserID + "=" + member.DisplayName)
+	}
+	return b.String()
+}
+
+func (g *guild) subject(userID, channelID string) filters.Subject {
+	subject := filters.Subject{
+		UserID:    userID,
+		ChannelID: channelID,
+	}
+	if member, ok := g.members[userID]; ok {
+		subject.Username = member.Username
+		subject.DisplayName = member.DisplayName
+		subject.Bot = member.Bot
+		subject.RoleIDs = member.RoleIDs
+		subject.HasRoles = true
+	}
+	if channel, ok := g.channels[channelID]; ok {
+		subject.ChannelName = channel.Name
+		subject.CategoryID = channel.ParentID
+		subject.CategoryName = g.channels[channel.ParentID].Name
+	}
+	return subject
+}
+
+func newMember(m *discordgo.Member) Member {
+	return Member{
+		UserID:      m.User.ID,
+		Username:    m.User.Username,
+		GlobalName:  m.User.GlobalName,
+		Nick:        m.Nick,
+		DisplayName: displayName(m),
+		Bot:         m.User.Bot,
+		RoleIDs:     m.Roles,
+	}
+}
+
+func newChannel(c *discordgo.Channel) Channel {
+	return Channel{
+		ID:       c.ID,
+		Name:     c.Name,
+		ParentID: c.ParentID,
+		Voice:    c.Type == discordgo.ChannelTypeGuildVoice || c.Type == discordgo.ChannelTypeGuildStageVoice,
+	}
+}
+
+func newVoiceState(vs *discordgo.VoiceState, joinedAt time.Time) VoiceState {
+	return VoiceState{
+		ChannelID:  vs.ChannelID,
+		SelfMute:   vs.SelfMute,
+		SelfDeaf:   vs.SelfDeaf,
+		SelfStream: vs.SelfStream,
+		SelfVideo:  vs.SelfVideo,
+		JoinedAt:   joinedAt,
+	}
+}
+
+func displayName(m *discordgo.Member) string {
+	switch {
+	case m.Nick != "":
+		return m.Nick
+	case m.User.GlobalName != "":
+		return m.User.GlobalName
+	default:
+		return m.User.Username
+	}
+}
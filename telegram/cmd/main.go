@@ -28,6 +28,11 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	me, err := b.GetMe(ctx)
+	if err != nil {
+		log.Fatalf("error fetching the bot user: %v", err)
+	}
+	botUsername = me.Username
 
 	// Start the bot in a goroutine
 	go func() {
@@ -47,15 +52,77 @@ func main() {
 		}
 	}()
 
+	// Post the scheduled reports
+	scheduler := handlers.NewReportScheduler()
+	go func() {
+		scheduler.Start(ctx, b)
+	}()
+
+	// Announce the unlocked achievements
+	announcer := handlers.NewAchievementAnnouncer()
+	go func() {
+		announcer.Start(ctx, b)
+	}()
+
 	// Wait for the context to be done
 	select {}
 }
 
+// botUsername is the username of the bot, commands addressed to other bots in a group are ignored
+var botUsername string
+
+// isCommand reports whether the message is the command, alone or addressed to this bot as in /command@bot
+func isCommand(update *models.Update, command string) bool {
+	if update.Message == nil {
+		return false
+	}
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) == 0 {
+		return false
+	}
+	name, target, addressed := strings.Cut(fields[0], "@")
+	return name == command && (!addressed || strings.EqualFold(target, botUsername))
+}
+
 func handler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	switch {
-	case update.Message != nil && update.Message.Text == "/status":
+	case isCommand(update, "/status"):
 		handlers.StatusHandler(ctx, b, update)
-	case update.Message != nil && strings.HasPrefix(update.Message.Text, "/voicestats"):
+	case isCommand(update, "/voicestats"):
 		handlers.UserStatsHandler(ctx, b, update)
+	case isCommand(update, "/rolestats"):
+		handlers.RoleStatsHandler(ctx, b, update)
+	case isCommand(update, "/leaderboard"):
+		handlers.LeaderboardHandler(ctx, b, update)
+	case isCommand(update, "/channelstats"):
+		handlers.ChannelStatsHandler(ctx, b, update)
+	case isCommand(update, "/buddygraph"):
+		handlers.BuddyGraphHandler(ctx, b, update)
+	case isCommand(update, "/buddies"):
+		handlers.BuddiesHandler(ctx, b, update)
+	case isCommand(update, "/heatmap"):
+		handlers.HeatmapHandler(ctx, b, update)
+	case isCommand(update, "/graph"):
+		handlers.GraphHandler(ctx, b, update)
+	case isCommand(update, "/unlink"):
+		handlers.UnlinkHandler(ctx, b, update)
+	case isCommand(update, "/link"):
+		handlers.LinkHandler(ctx, b, update)
+	case isCommand(update, "/me"):
+		handlers.MeHandler(ctx, b, update)
+	case isCommand(update, "/lastseen"):
+		handlers.LastSeenHandler(ctx, b, update)
+	case isCommand(update, "/history"):
+		handlers.HistoryHandler(ctx, b, update)
+	case isCommand(update, "/timezone"):
+		handlers.TimezoneHandler(ctx, b, update)
+	case isCommand(update, "/schedule"):
+		handlers.ScheduleHandler(ctx, b, update)
+	case isCommand(update, "/wrapped"):
+		handlers.WrappedHandler(ctx, b, update)
+	case isCommand(update, "/badges"):
+		handlers.BadgesHandler(ctx, b, update)
+	case isCommand(update, "/at"):
+		handlers.AtHandler(ctx, b, update)
 	}
 }
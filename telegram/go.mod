@@ -5,6 +5,7 @@ go 1.23.4
 require (
 	github.com/go-telegram/bot v1.11.1
 	github.com/vcaldo/cerverox9/discord v0.0.0-20250103112130-70319128faec
+	golang.org/x/image v0.23.0
 )
 
 require (
@@ -19,3 +20,5 @@ require (
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 )
+
+replace github.com/vcaldo/cerverox9/discord => ../discord
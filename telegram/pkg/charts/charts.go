@@ -0,0 +1,124 @@
+// Package charts renders stats as PNG images in pure Go so they can be sent with SendPhoto.
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	background = color.RGBA{0x1e, 0x1f, 0x22, 0xff}
+	foreground = color.RGBA{0xdb, 0xde, 0xe1, 0xff}
+	gridColor  = color.RGBA{0x3f, 0x41, 0x47, 0xff}
+	blurple    = color.RGBA{0x58, 0x65, 0xf2, 0xff}
+	green      = color.RGBA{0x57, 0xf2, 0x87, 0xff}
+	yellow     = color.RGBA{0xfe, 0xe7, 0x5c, 0xff}
+	empty      = color.RGBA{0x2b, 0x2d, 0x31, 0xff}
+)
+
+// canvas is an image with helpers to draw text and shapes
+type canvas struct {
+	*image.RGBA
+}
+
+func newCanvas(width, height int) *canvas {
+	c := &canvas{image.NewRGBA(image.Rect(0, 0, width, height))}
+	draw.Draw(c.RGBA, c.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	return c
+}
+
+// text draws the string with its baseline starting at x, y
+func (c *canvas) text(x, y int, col color.Color, s string) {
+	d := &font.Drawer{
+		Dst:  c.RGBA,
+		Src:  image.NewUniform(col),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+// textWidth returns the width in pixels of the string
+func textWidth(s string) int {
+	return font.MeasureString(basicfont.Face7x13, s).Round()
+}
+
+func (c *canvas) rect(x0, y0, x1, y1 int, col color.Color) {
+	draw.Draw(c.RGBA, image.Rect(x0, y0, x1, y1), image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+// line draws a line using Bresenham's algorithm
+func (c *canvas) line(x0, y0, x1, y1 int, col color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		c.Set(x0, y0, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// thickLine draws a line two pixels wide
+func (c *canvas) thickLine(x0, y0, x1, y1 int, col color.Color) {
+	c.line(x0, y0, x1, y1, col)
+	c.line(x0, y0+1, x1, y1+1, col)
+}
+
+// dot draws a filled square centered on x, y
+func (c *canvas) dot(x, y, radius int, col color.Color) {
+	c.rect(x-radius, y-radius, x+radius+1, y+radius+1, col)
+}
+
+func (c *canvas) png() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.RGBA); err != nil {
+		return nil, fmt.Errorf("error encoding png: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// gradient maps a value between 0 and 1 to a color going from blurple to yellow
+func gradient(value float64) color.Color {
+	if value <= 0 {
+		return empty
+	}
+	if value > 1 {
+		value = 1
+	}
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*value)
+	}
+	return color.RGBA{mix(blurple.R, yellow.R), mix(blurple.G, yellow.G), mix(blurple.B, yellow.B), 0xff}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,53 @@
+package charts
+
+import (
+	"fmt"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+)
+
+const (
+	heatmapCellWidth  = 28
+	heatmapCellHeight = 24
+	heatmapLeft       = 44
+	heatmapTop        = 48
+)
+
+var weekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// Heatmap renders a 7x24 grid of the values, format is used to print the largest value in the legend
+func Heatmap(title string, grid analytics.HourOfWeek, format string) ([]byte, error) {
+	width := heatmapLeft + 24*heatmapCellWidth + 16
+	height := heatmapTop + 7*heatmapCellHeight + 40
+	c := newCanvas(width, height)
+
+	c.text(heatmapLeft, 20, foreground, title)
+	for hour := 0; hour < 24; hour += 3 {
+		c.text(heatmapLeft+hour*heatmapCellWidth+2, heatmapTop-6, foreground, fmt.Sprintf("%02dh", hour))
+	}
+
+	max := grid.Max()
+	for day := range grid {
+		y := heatmapTop + day*heatmapCellHeight
+		c.text(8, y+heatmapCellHeight/2+4, foreground, weekdays[day])
+		for hour, value := range grid[day] {
+			x := heatmapLeft + hour*heatmapCellWidth
+			var intensity float64
+			if max > 0 {
+				intensity = value / max
+			}
+			c.rect(x+1, y+1, x+heatmapCellWidth-1, y+heatmapCellHeight-1, gradient(intensity))
+		}
+	}
+
+	// Legend from nothing to the largest value
+	legendY := heatmapTop + 7*heatmapCellHeight + 14
+	c.text(heatmapLeft, legendY+10, foreground, "0")
+	for i := 0; i < 10; i++ {
+		x := heatmapLeft + 16 + i*18
+		c.rect(x, legendY, x+16, legendY+12, gradient(float64(i+1)/10))
+	}
+	c.text(heatmapLeft+16+10*18+6, legendY+10, foreground, fmt.Sprintf(format, max))
+
+	return c.png()
+}
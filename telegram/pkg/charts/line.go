@@ -0,0 +1,132 @@
+package charts
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+)
+
+const (
+	lineWidth  = 800
+	lineHeight = 360
+	lineLeft   = 48
+	lineRight  = 24
+	lineTop    = 56
+	lineBottom = 40
+)
+
+// Series is a named line of a chart
+type Series struct {
+	Name   string
+	Color  color.Color
+	Points []analytics.Point
+}
+
+// OncallSeries and OnlineSeries use the colors the bots use for on call and online users
+func OncallSeries(points []analytics.Point) Series {
+	return Series{Name: "On call", Color: green, Points: points}
+}
+
+func OnlineSeries(points []analytics.Point) Series {
+	return Series{Name: "Online", Color: blurple, Points: points}
+}
+
+// LineChart renders the series between start and stop, marking the peak of each one.
+// Times are labelled in the given time zone.
+func LineChart(title string, series []Series, start, stop time.Time, loc *time.Location) ([]byte, error) {
+	c := newCanvas(lineWidth, lineHeight)
+	plotWidth := lineWidth - lineLeft - lineRight
+	plotHeight := lineHeight - lineTop - lineBottom
+
+	c.text(lineLeft, 20, foreground, title)
+
+	// Legend
+	legendX := lineLeft
+	for _, s := range series {
+		c.rect(legendX, 32, legendX+12, 44, s.Color)
+		c.text(legendX+18, 43, foreground, s.Name)
+		legendX += 18 + textWidth(s.Name) + 24
+	}
+
+	// Y axis from 0 to a round value above the largest point
+	var max float64
+	for _, s := range series {
+		for _, p := range s.Points {
+			max = math.Max(max, p.Value)
+		}
+	}
+	yMax := niceCeiling(max)
+	ticks := 4
+	for i := 0; i <= ticks; i++ {
+		value := yMax * float64(i) / float64(ticks)
+		y := lineTop + plotHeight - int(float64(plotHeight)*float64(i)/float64(ticks))
+		c.line(lineLeft, y, lineLeft+plotWidth, y, gridColor)
+		label := fmt.Sprintf("%g", value)
+		c.text(lineLeft-8-textWidth(label), y+4, foreground, label)
+	}
+
+	// X axis labels
+	span := stop.Sub(start)
+	layout := "15:04"
+	if span > 48*time.Hour {
+		layout = "Jan 02"
+	}
+	for i := 0; i <= 4; i++ {
+		t := start.Add(span * time.Duration(i) / 4)
+		label := t.In(loc).Format(layout)
+		x := lineLeft + plotWidth*i/4 - textWidth(label)/2
+		c.text(x, lineTop+plotHeight+20, foreground, label)
+	}
+
+	position := func(p analytics.Point) (int, int) {
+		x := lineLeft + int(float64(plotWidth)*float64(p.Time.Sub(start))/float64(span))
+		y := lineTop + plotHeight - int(float64(plotHeight)*p.Value/yMax)
+		return x, y
+	}
+
+	for _, s := range series {
+		for i := 1; i < len(s.Points); i++ {
+			x0, y0 := position(s.Points[i-1])
+			x1, y1 := position(s.Points[i])
+			c.thickLine(x0, y0, x1, y1, s.Color)
+		}
+
+		// Mark the first time the peak was reached
+		if len(s.Points) == 0 {
+			continue
+		}
+		peak := s.Points[0]
+		for _, p := range s.Points {
+			if p.Value > peak.Value {
+				peak = p
+			}
+		}
+		x, y := position(peak)
+		c.dot(x, y, 3, foreground)
+		label := fmt.Sprintf("peak %g at %s", math.Round(peak.Value), peak.Time.In(loc).Format(layout))
+		labelX := x + 6
+		if labelX+textWidth(label) > lineLeft+plotWidth {
+			labelX = x - 6 - textWidth(label)
+		}
+		c.text(labelX, y-6, s.Color, label)
+	}
+
+	return c.png()
+}
+
+// niceCeiling rounds the value up to 1, 2 or 5 times a power of ten, at least 1
+func niceCeiling(value float64) float64 {
+	if value <= 1 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(value)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if value <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+const (
+	atUsage       = "Usage: /at <" + period.TimeUsage + ">"
+	timezoneUsage = "Usage: /timezone [Area/City], like /timezone Europe/Madrid"
+)
+
+func AtHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	s := stats.NewStatsFromEnv()
+	loc := s.ChatLocation(update.Message.Chat.ID)
+
+	t, err := period.ParseTime(strings.Fields(update.Message.Text)[1:], time.Now(), loc)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Invalid time: %v\n%s", err, atUsage),
+		})
+		return
+	}
+	if t.After(time.Now()) {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "🔮 I can't tell who will be on call",
+		})
+		return
+	}
+
+	channels, err := s.GetOccupancyAt(t)
+	if err != nil {
+		log.Println("error rebuilding channel occupancy:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching who was on call",
+		})
+		return
+	}
+
+	occupancy := "Empty Discord. Crowded streets."
+	if len(channels) > 0 {
+		occupancy = formatChannelOccupancy(channels, t)
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("🕰️ On call on %s (%s)\n\n%s", t.Format(sessionTimeFmt+" 2006"), loc, occupancy),
+	})
+}
+
+// TimezoneHandler shows or sets the time zone times are read and shown in for the chat
+func TimezoneHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	s := stats.NewStatsFromEnv()
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("🌍 This chat uses the %s time zone\n%s", s.ChatLocation(update.Message.Chat.ID), timezoneUsage),
+		})
+		return
+	}
+
+	loc, err := time.LoadLocation(args[0])
+	if err != nil || len(args) > 1 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Unknown time zone %q\n%s", strings.Join(args, " "), timezoneUsage),
+		})
+		return
+	}
+
+	text := fmt.Sprintf("🌍 This chat now uses the %s time zone", loc)
+	err = s.SetChatTimezone(update.Message.Chat.ID, loc)
+	if err != nil {
+		log.Println("error setting chat time zone:", err)
+		text = "Error setting the time zone"
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+const (
+	achievementsCheckInterval     = 10 * time.Minute // Checks rebuild the achievements of the last stats.AchievementWindow
+	achievementsFullCheckInterval = 24 * time.Hour   // Full checks rebuild them from the whole voice history
+)
+
+// AchievementAnnouncer announces the achievements members unlock in the notifications chat
+type AchievementAnnouncer struct {
+	Stats  *stats.Stats
+	ChatID int64
+
+	lastFullCheck time.Time
+}
+
+func NewAchievementAnnouncer() *AchievementAnnouncer {
+	return &AchievementAnnouncer{
+		Stats:  stats.NewStatsFromEnv(),
+		ChatID: notificationsChatID(),
+	}
+}
+
+func (a *AchievementAnnouncer) Start(ctx context.Context, b *bot.Bot) {
+	if a.ChatID == 0 {
+		log.Println("TELEGRAM_CHAT_ID is not set, achievements won't be announced")
+		return
+	}
+
+	ticker := time.NewTicker(achievementsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			r := period.Range{Start: now.Add(-stats.AchievementWindow)}
+			full := now.Sub(a.lastFullCheck) >= achievementsFullCheckInterval
+			if full {
+				r = period.Range{}
+			}
+			unlocks, err := a.Stats.CheckAchievements(r)
+			if err != nil {
+				log.Printf("Error checking achievements: %v", err)
+				continue
+			}
+			if full {
+				a.lastFullCheck = now
+			}
+			if len(unlocks) == 0 {
+				continue
+			}
+			links, err := a.Stats.GetAccountLinks()
+			if err != nil {
+				log.Printf("Error fetching account links: %v", err)
+			}
+
+			// Linked users are mentioned, so the names are sent as HTML
+			for _, unlock := range unlocks {
+				name := html.EscapeString(unlock.DisplayName)
+				if account, ok := links[unlock.UserID]; ok {
+					name = fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, account.UserID, name)
+				}
+				_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+					ChatID:    a.ChatID,
+					Text:      fmt.Sprintf("🏅 %s unlocked %s <b>%s</b>: %s", name, unlock.Emoji, html.EscapeString(unlock.Name), html.EscapeString(unlock.Description)),
+					ParseMode: models.ParseModeHTML,
+				})
+				if err != nil {
+					log.Printf("Error announcing achievement: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func BadgesHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /badges <user>",
+		})
+		return
+	}
+
+	user, ok := resolveUser(ctx, b, update, strings.Join(args, " "))
+	if !ok {
+		return
+	}
+
+	s := stats.NewStatsFromEnv()
+	unlocks, err := s.GetBadges(user.UserID)
+	if err != nil {
+		log.Println("error fetching badges:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching the badges",
+		})
+		return
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   fmt.Sprintf("🏅 Badges of %s\n\n%s", user.Label(), stats.FormatBadges(unlocks, s.Location)),
+	})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+func BuddiesHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args, r := splitTrailingPeriod(strings.Fields(update.Message.Text)[1:])
+	targetUser := strings.Join(args, " ")
+	if targetUser == "" {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Usage: /buddies <user> [period], the period can be %s", period.Usage),
+		})
+		return
+	}
+
+	user, ok := resolveUser(ctx, b, update, targetUser)
+	if !ok {
+		return
+	}
+
+	pairs, err := stats.NewStatsFromEnv().GetCoPresence(r)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching call buddies",
+		})
+		return
+	}
+
+	buddies := analytics.Buddies(pairs, user.UserID, 10)
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("🫂 Call buddies of %s %s\n\n", user.Label(), r.Label))
+	for i, buddy := range buddies {
+		message.WriteString(fmt.Sprintf("%d. %s: %s together\n", i+1, buddy.DisplayName, stats.FormatDuration(buddy.Overlap)))
+	}
+	if len(buddies) == 0 {
+		message.WriteString("No shared calls found")
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   message.String(),
+	})
+}
+
+func BuddyGraphHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	format := "dot"
+	var periodArgs []string
+	for _, word := range strings.Fields(update.Message.Text)[1:] {
+		switch strings.ToLower(word) {
+		case "dot", "json":
+			format = strings.ToLower(word)
+		default:
+			periodArgs = append(periodArgs, word)
+		}
+	}
+
+	r, err := period.Parse(periodArgs, time.Now(), period.LocationFromEnv())
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Invalid period: %v\nUsage: /buddygraph [dot|json] [period]", err),
+		})
+		return
+	}
+
+	pairs, err := stats.NewStatsFromEnv().GetCoPresence(r)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching call buddies",
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if format == "json" {
+		err = analytics.WriteGraphJSON(&buf, pairs)
+	} else {
+		err = analytics.WriteGraphDOT(&buf, pairs)
+	}
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error building the buddies graph",
+		})
+		return
+	}
+
+	b.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:   update.Message.Chat.ID,
+		Document: &models.InputFileUpload{Filename: "buddies." + format, Data: &buf},
+		Caption:  fmt.Sprintf("🕸 Who hangs out with whom %s, weighted by shared minutes", r.Label),
+	})
+}
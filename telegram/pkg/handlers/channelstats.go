@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+func ChannelStatsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args, r := splitTrailingPeriod(strings.Fields(update.Message.Text)[1:])
+	channel := strings.TrimPrefix(strings.Join(args, " "), "#")
+	if channel == "" {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Usage: /channelstats <channel> [period], the period can be %s", period.Usage),
+		})
+		return
+	}
+
+	channelStats, err := stats.NewStatsFromEnv().GetChannelStats(channel, r)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("No stats found for channel %s %s", channel, r.Label),
+		})
+		return
+	}
+
+	busiestHours := []string{}
+	for _, hour := range channelStats.BusiestHours(3) {
+		busiestHours = append(busiestHours, fmt.Sprintf("%02d:00", hour))
+	}
+
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("🔊 Stats for channel %s %s\n\n", channelStats.ChannelName, r.Label))
+	message.WriteString(fmt.Sprintf("Occupied for %s\n", stats.FormatDuration(channelStats.Occupied)))
+	message.WriteString(fmt.Sprintf("%.1f users on average while occupied\n", channelStats.AverageUsers))
+	message.WriteString(fmt.Sprintf("Peak of %d users on %s\n", channelStats.PeakUsers, channelStats.PeakAt.In(period.LocationFromEnv()).Format("2006-01-02 15:04")))
+	message.WriteString(fmt.Sprintf("Busiest hours: %s\n\n", strings.Join(busiestHours, ", ")))
+	message.WriteString("Top users\n")
+	for i, entry := range channelStats.TopUsers {
+		message.WriteString(fmt.Sprintf("%d. %s: %s\n", i+1, entry.DisplayName, stats.FormatDuration(entry.Total)))
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   message.String(),
+	})
+}
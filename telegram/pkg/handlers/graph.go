@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+	"github.com/vcaldo/cerverox9/telegram/pkg/charts"
+)
+
+// graphPoints is about how many points each line of a graph has
+const graphPoints = 150
+
+func GraphHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) == 0 {
+		args = []string{"24h"}
+	}
+
+	now := time.Now()
+	loc := period.LocationFromEnv()
+	r, err := period.Parse(args, now, loc)
+	if err != nil || r.Start.IsZero() {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /graph [24h|7d|30d]",
+		})
+		return
+	}
+	stop := r.Stop
+	if stop.IsZero() || stop.After(now) {
+		stop = now
+	}
+
+	every := stop.Sub(r.Start) / graphPoints
+	every = every.Truncate(time.Minute)
+	if every < time.Minute {
+		every = time.Minute
+	}
+
+	oncall, online, err := stats.NewStatsFromEnv().GetUsersCountSeries(r, every)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching users series",
+		})
+		return
+	}
+
+	image, err := charts.LineChart(
+		fmt.Sprintf("Users on call and online %s", r.Label),
+		[]charts.Series{charts.OnlineSeries(online), charts.OncallSeries(oncall)},
+		r.Start, stop, loc,
+	)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error rendering graph",
+		})
+		return
+	}
+
+	b.SendPhoto(ctx, &bot.SendPhotoParams{
+		ChatID: update.Message.Chat.ID,
+		Photo:  &models.InputFileUpload{Filename: "graph.png", Data: bytes.NewReader(image)},
+	})
+}
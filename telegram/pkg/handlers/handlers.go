@@ -3,18 +3,24 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"html"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
-	"github.com/vcaldo/cerverox9/telegram/pkg/stats"
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	discordmodels "github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
 )
 
 func StatusHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	guildName, oncallUsersCount, oncallUsers, onlineUsersCount, onlineUsers, err := stats.GetVoiceCallStatus()
+	status, err := stats.NewStatsFromEnv().GetStatus()
 	if err != nil {
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
@@ -23,23 +29,39 @@ func StatusHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 		return
 	}
 
-	oncallUsersList := strings.Split(oncallUsers, ",")
-	oncallUsersListLinebreak := strings.Join(oncallUsersList, "\n")
-	onlineUsersList := strings.Split(onlineUsers, ",")
-	onlineUsersListLinebreak := strings.Join(onlineUsersList, "\n")
+	oncallUsersListLinebreak := strings.Join(status.Oncall, "\n")
+	if status.OncallCount == 0 {
+		oncallUsersListLinebreak = "Empty Discord. Crowded streets."
+	}
+	// Group the users by channel when the Discord bot logs the channel members, the flat list is kept as a fallback
+	if len(status.Channels) > 0 {
+		oncallUsersListLinebreak = formatChannelOccupancy(status.Channels, time.Now())
+	}
+	onlineUsersListLinebreak := strings.Join(status.Online, "\n")
 	discordInviteLink := os.Getenv("DISCORD_INVITE_LINK")
 
+	trend := ""
+	if len(status.Last24h) > 0 {
+		trend = fmt.Sprintf("Last 24h: %s\n", analytics.Sparkline(status.Last24h))
+		if status.HasUsual {
+			trend += status.UsualComparison(time.Now().In(period.LocationFromEnv())) + "\n"
+		}
+		trend += "\n"
+	}
+
 	message := fmt.Sprintf(
 		"Live stats for Discord Server %s\n\n"+
 			"We have %d users having fun in the call\n\n"+
 			"%s\n\n"+
+			"%s"+
 			"There are %d users who are one click away from having fun\n\n"+
 			"%s\n\n"+
 			"🥳 Join the party! 🥳\n%s",
-		guildName,
-		oncallUsersCount,
+		status.GuildName,
+		status.OncallCount,
 		oncallUsersListLinebreak,
-		onlineUsersCount,
+		trend,
+		status.OnlineCount,
 		onlineUsersListLinebreak,
 		discordInviteLink,
 	)
@@ -51,7 +73,7 @@ func StatusHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 		"🫥", "⚰️", "🦠", "🙊", "😴", "😤", "🤬", "🥶", "🧟", "🕸", "☠️", "💤", "❄️", "😶", "🤚", "😓", "😫", "💩", "🤐", "🕊", "🗝", "🤨", "👹", "👺", "🫠", "😶‍🌫️", "😵", "🙉", "🦴", "🎟", "🏴", "⛈", "🤦‍♂️", "🦟", "🦝", "🖕", "💔", "🫵", "🤰", "🦍",
 	}
 	emojiMessage := emojis[rand.Intn(len(emojis))]
-	if oncallUsersCount == 0 {
+	if status.OncallCount == 0 {
 		emojiMessage = emptyEmojis[rand.Intn(len(emptyEmojis))]
 	}
 	b.SendMessage(ctx, &bot.SendMessageParams{
@@ -66,6 +88,21 @@ func StatusHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	})
 }
 
+// formatChannelOccupancy lists the users of each voice channel with their media icons and how long they've been in
+func formatChannelOccupancy(channels []discordmodels.ChannelOccupancy, now time.Time) string {
+	var b strings.Builder
+	for i, channel := range channels {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(fmt.Sprintf("🔊 %s (%d)\n", channel.ChannelName, len(channel.Members)))
+		for _, member := range channel.Members {
+			b.WriteString(fmt.Sprintf("  %s\n", stats.FormatChannelMember(member, now)))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
 func VoiceEventHanlder(ctx context.Context, b *bot.Bot, event *VoiceEvent) {
 	chatId, ok := os.LookupEnv("TELEGRAM_CHAT_ID")
 	if !ok {
@@ -77,47 +114,60 @@ func VoiceEventHanlder(ctx context.Context, b *bot.Bot, event *VoiceEvent) {
 		panic("TELEGRAM_CHAT_ID must be a valid int64")
 	}
 
+	// Linked users are mentioned, so the names are sent as HTML
+	name := html.EscapeString(event.UserGlobalName)
+	if event.TelegramUserID != 0 {
+		name = fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, event.TelegramUserID, name)
+	}
+	channelName := html.EscapeString(event.ChannelName)
+
 	switch {
 	// User joined the voice channel
 	case event.EventType == "voice" && event.State:
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: chatIdInt,
-			Text:   fmt.Sprintf("%s joined %s 🏃‍♂️", event.UserGlobalName, event.ChannelName),
+			ChatID:    chatIdInt,
+			Text:      fmt.Sprintf("%s joined %s 🏃‍♂️", name, channelName),
+			ParseMode: models.ParseModeHTML,
 		})
 	// User left the voice channel
 	case event.EventType == "voice" && !event.State:
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: chatIdInt,
-			Text:   fmt.Sprintf("%s left %s 🏃‍♂️‍➡️", event.UserGlobalName, event.ChannelName),
+			ChatID:    chatIdInt,
+			Text:      fmt.Sprintf("%s left %s 🏃‍♂️‍➡️", name, channelName),
+			ParseMode: models.ParseModeHTML,
 		})
 	case event.EventType == "webcam" && event.State:
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: chatIdInt,
-			Text:   fmt.Sprintf("%s opened the webcam in %s 📸", event.UserGlobalName, event.ChannelName),
+			ChatID:    chatIdInt,
+			Text:      fmt.Sprintf("%s opened the webcam in %s 📸", name, channelName),
+			ParseMode: models.ParseModeHTML,
 		})
 	case event.EventType == "streaming" && event.State:
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: chatIdInt,
-			Text:   fmt.Sprintf("%s started streaming in %s 📺 ", event.UserGlobalName, event.ChannelName),
+			ChatID:    chatIdInt,
+			Text:      fmt.Sprintf("%s started streaming in %s 📺 ", name, channelName),
+			ParseMode: models.ParseModeHTML,
 		})
 	}
 }
 
 func UserStatsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	messageText := update.Message.Text
-	words := strings.Fields(messageText)
-	var targetUser string
-	if len(words) < 2 {
+	args, r := splitTrailingPeriod(strings.Fields(update.Message.Text)[1:])
+	targetUser := strings.Join(args, " ")
+	if targetUser == "" {
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "Please provide a valid username",
+			Text:   fmt.Sprintf("Usage: /voicestats <user> [period], the user can be a username, display name, nickname, mention or ID and the period can be %s", period.Usage),
 		})
 		return
 	}
-	// Get the first word after /voicestats
-	targetUser = words[1]
 
-	userStats, err := stats.GetUserVoiceCallStatus(targetUser)
+	user, ok := resolveUser(ctx, b, update, targetUser)
+	if !ok {
+		return
+	}
+
+	userStats, err := stats.NewStatsFromEnv().GetUserVoiceTime(user.UserID, r)
 	if err != nil {
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
@@ -125,11 +175,9 @@ func UserStatsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 		})
 		return
 	}
-	hours := int(userStats.Hours())
-	minutes := int(userStats.Minutes()) % 60
 	message := fmt.Sprintf(
-		"📊 Total on-call hours for user %s this year: %dh:%dm",
-		targetUser, hours, minutes,
+		"📊 Total on-call hours for user %s %s: %s",
+		user.Label(), r.Label, stats.FormatDuration(userStats),
 	)
 
 	b.SendMessage(ctx, &bot.SendMessageParams{
@@ -137,3 +185,64 @@ func UserStatsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 		Text:   message,
 	})
 }
+
+func RoleStatsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	// Everything after /rolestats but the period is the role name, role names can have spaces and the role is optional
+	args := strings.Fields(update.Message.Text)[1:]
+	r, ok := periodArgs(args)
+	if ok {
+		args = nil
+	} else {
+		args, r = splitTrailingPeriod(args)
+	}
+	targetRole := strings.Join(args, " ")
+
+	roles, err := stats.NewStatsFromEnv().GetRoleVoiceTime(r)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching role stats",
+		})
+		return
+	}
+
+	var message strings.Builder
+	if targetRole == "" {
+		message.WriteString(fmt.Sprintf("📊 On-call hours by role %s\n\n", r.Label))
+		for _, role := range roles {
+			message.WriteString(fmt.Sprintf("%s: %s\n", role.RoleName, stats.FormatDuration(role.Total)))
+		}
+		if len(roles) == 0 {
+			message.WriteString(fmt.Sprintf("No voice time recorded for any role %s", r.Label))
+		}
+	} else {
+		var found bool
+		for _, role := range roles {
+			if !strings.EqualFold(role.RoleName, targetRole) && role.RoleID != targetRole {
+				continue
+			}
+			found = true
+
+			userIDs := make([]string, 0, len(role.Members))
+			for userID := range role.Members {
+				userIDs = append(userIDs, userID)
+			}
+			sort.Slice(userIDs, func(i, j int) bool {
+				return role.Members[userIDs[i]] > role.Members[userIDs[j]]
+			})
+
+			message.WriteString(fmt.Sprintf("📊 On-call hours for role %s %s: %s\n\n", role.RoleName, r.Label, stats.FormatDuration(role.Total)))
+			for _, userID := range userIDs {
+				message.WriteString(fmt.Sprintf("%s: %s\n", role.Names[userID], stats.FormatDuration(role.Members[userID])))
+			}
+		}
+		if !found {
+			message.WriteString(fmt.Sprintf("No voice time recorded for role %s %s", targetRole, r.Label))
+		}
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   message.String(),
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/analytics"
+	"github.com/vcaldo/cerverox9/discord/pkg/identity"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+	"github.com/vcaldo/cerverox9/telegram/pkg/charts"
+)
+
+// heatmapMaxWeeks caps the weeks a heatmap is averaged over, every request reads all of their sessions
+const heatmapMaxWeeks = 52
+
+func HeatmapHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args := strings.Fields(update.Message.Text)[1:]
+
+	// A trailing number is the amount of weeks, anything before it is the user
+	weeks := 4
+	if len(args) > 0 {
+		if value, err := strconv.Atoi(args[len(args)-1]); err == nil && value > 0 {
+			weeks = min(value, heatmapMaxWeeks)
+			args = args[:len(args)-1]
+		}
+	}
+	targetUser := strings.Join(args, " ")
+	var user identity.User
+	if targetUser != "" {
+		var ok bool
+		user, ok = resolveUser(ctx, b, update, targetUser)
+		if !ok {
+			return
+		}
+	}
+
+	grid, err := stats.NewStatsFromEnv().GetHeatmap(user.UserID, weeks)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching heatmap",
+		})
+		return
+	}
+
+	title := fmt.Sprintf("Average users on call by hour, last %d weeks", weeks)
+	format := "%.1f users"
+	if targetUser != "" {
+		title = fmt.Sprintf("%s on call by hour, last %d weeks", user.Label(), weeks)
+		grid = toPercent(grid)
+		format = "%.0f%% of the time"
+	}
+
+	image, err := charts.Heatmap(title, grid, format)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error rendering heatmap",
+		})
+		return
+	}
+
+	b.SendPhoto(ctx, &bot.SendPhotoParams{
+		ChatID: update.Message.Chat.ID,
+		Photo:  &models.InputFileUpload{Filename: "heatmap.png", Data: bytes.NewReader(image)},
+	})
+}
+
+func toPercent(grid analytics.HourOfWeek) analytics.HourOfWeek {
+	for day := range grid {
+		for hour := range grid[day] {
+			grid[day][hour] *= 100
+		}
+	}
+	return grid
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+const (
+	historyUsage   = "Usage: /history <user> [n]"
+	historyMaxSize = 25
+	sessionTimeFmt = "Mon 02 Jan 15:04"
+	lastseenUsage  = "Usage: /lastseen <user>"
+)
+
+func LastSeenHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args := strings.Fields(update.Message.Text)[1:]
+	if len(args) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   lastseenUsage,
+		})
+		return
+	}
+
+	user, ok := resolveUser(ctx, b, update, strings.Join(args, " "))
+	if !ok {
+		return
+	}
+
+	session, ok, err := stats.NewStatsFromEnv().GetLastSession(user.UserID)
+	if err != nil {
+		log.Println("error fetching last session:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching the last session",
+		})
+		return
+	}
+
+	loc := period.LocationFromEnv()
+	message := fmt.Sprintf("👀 %s was never seen in a voice channel", user.Label())
+	switch {
+	case ok && session.Ongoing:
+		message = fmt.Sprintf("🟢 %s is on call in %s since %s, for %s",
+			user.Label(), session.ChannelName, session.Start.In(loc).Format(sessionTimeFmt), stats.FormatDuration(session.Duration()))
+	case ok:
+		message = fmt.Sprintf("👀 %s was last seen in %s on %s, for %s",
+			user.Label(), session.ChannelName, session.End.In(loc).Format(sessionTimeFmt), stats.FormatDuration(session.Duration()))
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   message,
+	})
+}
+
+func HistoryHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	args := strings.Fields(update.Message.Text)[1:]
+	n := 10
+	// A trailing number is the number of sessions, names with spaces come first
+	if len(args) > 1 {
+		if value, err := strconv.Atoi(args[len(args)-1]); err == nil && value > 0 {
+			n = min(value, historyMaxSize)
+			args = args[:len(args)-1]
+		}
+	}
+	if len(args) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   historyUsage,
+		})
+		return
+	}
+
+	user, ok := resolveUser(ctx, b, update, strings.Join(args, " "))
+	if !ok {
+		return
+	}
+
+	entries, err := stats.NewStatsFromEnv().GetUserHistory(user.UserID, n)
+	if err != nil {
+		log.Println("error fetching user history:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching the history",
+		})
+		return
+	}
+	if len(entries) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("👀 %s was never seen in a voice channel", user.Label()),
+		})
+		return
+	}
+
+	loc := period.LocationFromEnv()
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("🕰️ Latest sessions of %s\n\n", user.Label()))
+	for _, entry := range entries {
+		ongoing := ""
+		if entry.Ongoing {
+			ongoing = " 🟢"
+		}
+		message.WriteString(fmt.Sprintf("%s · %s · %s%s%s\n",
+			entry.Start.In(loc).Format(sessionTimeFmt), entry.ChannelName, stats.FormatDuration(entry.Duration()), stats.HistoryIcons(entry), ongoing))
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   message.String(),
+	})
+}
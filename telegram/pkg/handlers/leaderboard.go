@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	discordmodels "github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+const leaderboardUsage = "Usage: /leaderboard [streaming|webcam] [#channel] [period] [n]"
+
+var leaderboardEventTypes = map[string]string{
+	"voice":     discordmodels.VoiceEvent,
+	"streaming": discordmodels.StreamEvent,
+	"stream":    discordmodels.StreamEvent,
+	"webcam":    discordmodels.WebcamEvent,
+	"cam":       discordmodels.WebcamEvent,
+}
+
+func LeaderboardHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	eventType := discordmodels.VoiceEvent
+	var channel string
+	n := 10
+
+	// Keywords can come in any order, whatever is left is the period
+	var periodArgs []string
+	for _, word := range strings.Fields(update.Message.Text)[1:] {
+		if t, ok := leaderboardEventTypes[strings.ToLower(word)]; ok {
+			eventType = t
+			continue
+		}
+		if strings.HasPrefix(word, "#") && len(word) > 1 {
+			channel = word[1:]
+			continue
+		}
+		if value, err := strconv.Atoi(word); err == nil && value > 0 {
+			n = value
+			continue
+		}
+		periodArgs = append(periodArgs, word)
+	}
+
+	r, err := period.Parse(periodArgs, time.Now(), period.LocationFromEnv())
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Invalid period: %v\n%s", err, leaderboardUsage),
+		})
+		return
+	}
+
+	entries, err := stats.NewStatsFromEnv().GetLeaderboard(r, eventType, channel, n)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching leaderboard",
+		})
+		return
+	}
+
+	message := fmt.Sprintf("%s\n\n%s", stats.LeaderboardTitle(eventType, channel, r), stats.FormatLeaderboard(entries))
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   message,
+	})
+}
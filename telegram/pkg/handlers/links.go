@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/identity"
+	discordmodels "github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+func LinkHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	// Anyone who sees the code can claim it from Discord, so it's only given in a private chat
+	if update.Message.Chat.Type != models.ChatTypePrivate || update.Message.From == nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "🔒 Send /link to me in a private chat to link your Discord account",
+		})
+		return
+	}
+
+	code, err := stats.NewStatsFromEnv().CreateLinkCode(discordmodels.TelegramAccount{
+		UserID:   update.Message.From.ID,
+		Username: update.Message.From.Username,
+	})
+	if err != nil {
+		log.Println("error creating link code:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error creating a link code",
+		})
+		return
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text: fmt.Sprintf(
+			"🔗 Your link code is %s\n\n"+
+				"Use /link %s in the Discord server or send it to the Discord bot in a DM within %d minutes.",
+			code, code, int(discordmodels.LinkCodeTTL.Minutes()),
+		),
+	})
+}
+
+func UnlinkHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message.From == nil {
+		return
+	}
+
+	text := "Your Discord account is no longer linked"
+	err := stats.NewStatsFromEnv().Unlink(update.Message.From.ID)
+	if err != nil {
+		log.Println("error unlinking account:", err)
+		text = "Error unlinking your Discord account"
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+}
+
+func MeHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message.From == nil {
+		return
+	}
+	r, _ := periodArgs(strings.Fields(update.Message.Text)[1:])
+	s := stats.NewStatsFromEnv()
+
+	discordUserID, ok, err := s.GetLinkedDiscordUser(update.Message.From.ID)
+	if err != nil {
+		log.Println("error fetching linked account:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching your linked Discord account",
+		})
+		return
+	}
+	if !ok {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Your Discord account isn't linked yet, send /link to me in a private chat",
+		})
+		return
+	}
+
+	// Users that never showed up in the server are still shown by ID
+	user, err := s.ResolveUser(discordUserID)
+	var notFound *identity.NotFoundError
+	if errors.As(err, &notFound) {
+		user = identity.User{UserID: discordUserID, Username: discordUserID}
+		err = nil
+	}
+	if err != nil {
+		log.Println("error resolving linked user:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching your stats",
+		})
+		return
+	}
+
+	entries, err := s.GetLeaderboard(r, discordmodels.VoiceEvent, "", 0)
+	if err != nil {
+		log.Println("error fetching leaderboard:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error fetching your stats",
+		})
+		return
+	}
+
+	message := fmt.Sprintf("📊 %s %s\n\nNo voice time yet", user.Label(), r.Label)
+	for i, entry := range entries {
+		if entry.UserID == user.UserID {
+			message = fmt.Sprintf(
+				"📊 %s %s\n\nVoice time: %s in %d sessions\nRank: #%d of %d",
+				user.Label(), r.Label, stats.FormatDuration(entry.Total), entry.Sessions, i+1, len(entries),
+			)
+			break
+		}
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   message,
+	})
+}
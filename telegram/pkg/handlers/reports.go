@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	discordmodels "github.com/vcaldo/cerverox9/discord/pkg/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+const (
+	scheduleUsage     = "Usage: /schedule [weekly|monthly] [HH:MM|off]"
+	defaultReportTime = "10:00"
+	wrappedPostTime   = "20:00" // On December 31, in the stats time zone
+)
+
+// ReportScheduler posts the scheduled reports, weekly ones on Mondays and monthly ones on the first day of the month,
+// and the server Wrapped on December 31. Posts are recorded in InfluxDB, so each report is posted once per chat
+// and period across restarts.
+type ReportScheduler struct {
+	Stats         *stats.Stats
+	WrappedChatID int64 // The notifications chat, no Wrapped is posted when it's 0
+
+	// posted remembers the posts that couldn't be recorded, so they aren't repeated every minute
+	posted map[string]bool
+}
+
+func NewReportScheduler() *ReportScheduler {
+	return &ReportScheduler{
+		Stats:         stats.NewStatsFromEnv(),
+		WrappedChatID: notificationsChatID(),
+		posted:        map[string]bool{},
+	}
+}
+
+func (r *ReportScheduler) Start(ctx context.Context, b *bot.Bot) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			schedules, err := r.Stats.GetReportSchedules()
+			if err != nil {
+				log.Printf("Error fetching report schedules: %v", err)
+				continue
+			}
+			for _, schedule := range schedules {
+				r.post(ctx, b, schedule)
+			}
+			r.postWrapped(ctx, b)
+		}
+	}
+}
+
+// post sends the report when it's due in the time zone of the chat and wasn't posted yet
+func (r *ReportScheduler) post(ctx context.Context, b *bot.Bot, schedule discordmodels.ReportSchedule) {
+	now := time.Now().In(r.Stats.ChatLocation(schedule.ChatID))
+	if !reportDue(schedule, now) {
+		return
+	}
+
+	_, _, key := stats.ReportRanges(schedule.Report, now)
+	postKey := fmt.Sprintf("%d/%s/%s", schedule.ChatID, schedule.Report, key)
+	if r.posted[postKey] {
+		return
+	}
+	posted, err := r.Stats.IsReportPosted(schedule.ChatID, schedule.Report, key)
+	if err != nil {
+		log.Printf("Error checking %s report post: %v", schedule.Report, err)
+		return
+	}
+	if posted {
+		return
+	}
+
+	recap, err := r.Stats.GetRecap(schedule.Report, now)
+	if err != nil {
+		log.Printf("Error building %s report: %v", schedule.Report, err)
+		return
+	}
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: schedule.ChatID,
+		Text:   stats.FormatRecap(recap),
+	})
+	if err != nil {
+		log.Printf("Error posting %s report to chat %d: %v", schedule.Report, schedule.ChatID, err)
+		return
+	}
+
+	r.posted[postKey] = true
+	err = r.Stats.LogReportPost(schedule.ChatID, schedule.Report, key)
+	if err != nil {
+		log.Printf("Error recording %s report post: %v", schedule.Report, err)
+	}
+}
+
+// postWrapped sends the server Wrapped of the year to the notifications chat on the evening of December 31
+func (r *ReportScheduler) postWrapped(ctx context.Context, b *bot.Bot) {
+	now := time.Now().In(r.Stats.Location)
+	if r.WrappedChatID == 0 || now.Month() != time.December || now.Day() != 31 || now.Format("15:04") < wrappedPostTime {
+		return
+	}
+
+	key := strconv.Itoa(now.Year())
+	postKey := fmt.Sprintf("%d/%s/%s", r.WrappedChatID, discordmodels.WrappedReport, key)
+	if r.posted[postKey] {
+		return
+	}
+	posted, err := r.Stats.IsReportPosted(r.WrappedChatID, discordmodels.WrappedReport, key)
+	if err != nil {
+		log.Printf("Error checking wrapped post: %v", err)
+		return
+	}
+	if posted {
+		return
+	}
+
+	wrapped, err := r.Stats.GetWrapped("", now.Year())
+	if err != nil {
+		log.Printf("Error building wrapped: %v", err)
+		return
+	}
+	// A Wrapped cut halfway is still recorded, posting it twice would be worse
+	r.posted[postKey] = true
+	err = sendSlides(ctx, b, r.WrappedChatID, stats.FormatWrapped(wrapped, "", now.Year(), r.Stats.Location))
+	if err != nil {
+		log.Printf("Error posting wrapped to chat %d: %v", r.WrappedChatID, err)
+	}
+	err = r.Stats.LogReportPost(r.WrappedChatID, discordmodels.WrappedReport, key)
+	if err != nil {
+		log.Printf("Error recording wrapped post: %v", err)
+	}
+}
+
+// reportDue reports whether the scheduled report is posted on the day of the time and its time of day has passed
+func reportDue(schedule discordmodels.ReportSchedule, now time.Time) bool {
+	switch schedule.Report {
+	case discordmodels.WeeklyReport:
+		if now.Weekday() != time.Monday {
+			return false
+		}
+	case discordmodels.MonthlyReport:
+		if now.Day() != 1 {
+			return false
+		}
+	default:
+		return false
+	}
+	// Times are compared in minutes since midnight, schedules stored before they were normalised can be as 9:00
+	at, err := time.Parse("15:04", schedule.At)
+	if err != nil {
+		return false
+	}
+	return now.Hour()*60+now.Minute() >= at.Hour()*60+at.Minute()
+}
+
+// ScheduleHandler lists the reports scheduled in the chat, or schedules one at a time of day in the chat time zone
+func ScheduleHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	s := stats.NewStatsFromEnv()
+	args := strings.Fields(strings.ToLower(update.Message.Text))[1:]
+
+	if len(args) == 0 {
+		schedules, err := s.GetReportSchedules()
+		if err != nil {
+			log.Println("error fetching report schedules:", err)
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Error fetching the report schedules",
+			})
+			return
+		}
+		var message strings.Builder
+		for _, schedule := range schedules {
+			if schedule.ChatID == update.Message.Chat.ID {
+				message.WriteString(fmt.Sprintf("⏰ %s report at %s\n", schedule.Report, schedule.At))
+			}
+		}
+		if message.Len() == 0 {
+			message.WriteString("No reports scheduled in this chat\n")
+		}
+		message.WriteString(fmt.Sprintf("\nTimes are in the %s time zone, see /timezone\n%s", s.ChatLocation(update.Message.Chat.ID), scheduleUsage))
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   message.String(),
+		})
+		return
+	}
+
+	report := args[0]
+	if (report != discordmodels.WeeklyReport && report != discordmodels.MonthlyReport) || len(args) > 2 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   scheduleUsage,
+		})
+		return
+	}
+
+	at := defaultReportTime
+	if len(args) == 2 {
+		at = args[1]
+	}
+	if at == "off" {
+		at = ""
+	} else {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   fmt.Sprintf("Invalid time %q\n%s", at, scheduleUsage),
+			})
+			return
+		}
+		// 9:00 is stored as 09:00
+		at = t.Format("15:04")
+	}
+
+	text := fmt.Sprintf("⏰ The %s report will be posted here at %s", report, at)
+	if at == "" {
+		text = fmt.Sprintf("The %s report won't be posted here anymore", report)
+	}
+	err := s.SetReportSchedule(update.Message.Chat.ID, report, at)
+	if err != nil {
+		log.Println("error setting report schedule:", err)
+		text = "Error setting the report schedule"
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	discordmodels "github.com/vcaldo/cerverox9/discord/pkg/models"
+)
+
+func TestReportDue(t *testing.T) {
+	// 2024-07-01 is a Monday and the first of the month
+	monday := func(hour, minute int) time.Time {
+		return time.Date(2024, time.July, 1, hour, minute, 0, 0, time.UTC)
+	}
+	tuesday := time.Date(2024, time.July, 2, 12, 0, 0, 0, time.UTC)
+	secondMonday := time.Date(2024, time.July, 8, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		report string
+		at     string
+		now    time.Time
+		want   bool
+	}{
+		{discordmodels.WeeklyReport, "09:00", monday(8, 59), false},
+		{discordmodels.WeeklyReport, "09:00", monday(9, 0), true},
+		{discordmodels.WeeklyReport, "09:00", monday(23, 59), true},
+		{discordmodels.WeeklyReport, "9:00", monday(10, 0), true}, // Stored before the times were normalised
+		{discordmodels.WeeklyReport, "9:00", monday(8, 0), false},
+		{discordmodels.WeeklyReport, "21:30", monday(21, 29), false},
+		{discordmodels.WeeklyReport, "21:30", monday(21, 30), true},
+		{discordmodels.WeeklyReport, "09:00", tuesday, false},
+		{discordmodels.WeeklyReport, "09:00", secondMonday, true},
+		{discordmodels.MonthlyReport, "09:00", monday(10, 0), true},
+		{discordmodels.MonthlyReport, "09:00", secondMonday, false},
+		{discordmodels.MonthlyReport, "09:00", tuesday, false},
+		{discordmodels.WeeklyReport, "", monday(10, 0), false},
+		{discordmodels.WeeklyReport, "soon", monday(10, 0), false},
+		{discordmodels.WrappedReport, "09:00", monday(10, 0), false},
+	}
+
+	for _, tt := range tests {
+		schedule := discordmodels.ReportSchedule{ChatID: 1, Report: tt.report, At: tt.at}
+		if got := reportDue(schedule, tt.now); got != tt.want {
+			t.Errorf("reportDue(%s at %q, %v) = %v, want %v", tt.report, tt.at, tt.now, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/identity"
+	"github.com/vcaldo/cerverox9/discord/pkg/period"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+// splitTrailingPeriod reads an optional period at the end of the arguments and returns what comes before it,
+// so names with spaces can come first. The period is only read when something is left before it, so a user
+// named like a period, such as week, can be looked up alone. No period means the current year.
+func splitTrailingPeriod(args []string) ([]string, period.Range) {
+	now := time.Now()
+	loc := period.LocationFromEnv()
+	for n := 2; n >= 1; n-- {
+		if len(args) <= n {
+			continue
+		}
+		r, err := period.Parse(args[len(args)-n:], now, loc)
+		if err == nil {
+			return args[:len(args)-n], r
+		}
+	}
+	return args, period.Year(now.In(loc))
+}
+
+// periodArgs reads all the arguments as a period, for commands that take nothing else before it.
+// No arguments means the current year, and it returns false with the current year when they aren't a period.
+func periodArgs(args []string) (period.Range, bool) {
+	now := time.Now()
+	loc := period.LocationFromEnv()
+	r, err := period.Parse(args, now, loc)
+	if err != nil {
+		return period.Year(now.In(loc)), false
+	}
+	return r, true
+}
+
+// resolveUser finds the Discord user the query refers to. When there's no single match it
+// replies with the suggestions or the candidates and returns false.
+func resolveUser(ctx context.Context, b *bot.Bot, update *models.Update, query string) (identity.User, bool) {
+	user, err := stats.NewStatsFromEnv().ResolveUser(query)
+	if err == nil {
+		return user, true
+	}
+
+	log.Println("error resolving user:", err)
+	text := "Error looking up the user"
+	var notFound *identity.NotFoundError
+	var ambiguous *identity.AmbiguousError
+	switch {
+	case errors.As(err, &notFound) && len(notFound.Suggestions) > 0:
+		text = fmt.Sprintf("🤷 No user matches %q. Did you mean %s?", query, identity.Labels(notFound.Suggestions, " or "))
+	case errors.As(err, &notFound):
+		text = fmt.Sprintf("🤷 No user matches %q", query)
+	case errors.As(err, &ambiguous):
+		text = fmt.Sprintf("🤔 %q could be %s, try their username or mention", query, identity.Labels(ambiguous.Matches, ", "))
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+	return identity.User{}, false
+}
+
+// notificationsChatID returns the chat given by TELEGRAM_CHAT_ID, 0 when it's not set
+func notificationsChatID() int64 {
+	chatID, ok := os.LookupEnv("TELEGRAM_CHAT_ID")
+	if !ok {
+		return 0
+	}
+	id, err := strconv.ParseInt(chatID, 10, 64)
+	if err != nil {
+		log.Fatal("TELEGRAM_CHAT_ID must be a valid int64")
+	}
+	return id
+}
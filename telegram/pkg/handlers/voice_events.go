@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/vcaldo/cerverox9/discord/pkg/flux"
 	"github.com/vcaldo/cerverox9/discord/pkg/models"
 )
 
@@ -18,19 +19,34 @@ type VoiceEvent struct {
 	ChannelName    string `json:"channel_name"`
 	EventType      string `json:"event_type"`
 	State          bool   `json:"state"`
+	TelegramUserID int64  `json:"telegram_user_id,omitempty"` // Telegram user linked to the Discord user, 0 when not linked
 }
 
 type VoiceEventListener struct {
 	Metrics     *models.DiscordMetrics
 	LastChecked time.Time
 	NotifyChan  chan VoiceEvent
+
+	links          map[string]models.TelegramAccount
+	linksCheckedAt time.Time
+
+	// seen holds the events already notified within the poll overlap, keyed by series and time
+	seen map[string]time.Time
 }
 
+// pollOverlap is how far back each poll reads before the previous one. Events are stamped when the Discord bot
+// receives them but written a bit later, so a point can land behind a window that was already read.
+const pollOverlap = time.Minute
+
+// linksRefreshInterval is how long the account links are cached, new links show up in notifications after it
+const linksRefreshInterval = time.Minute
+
 func NewVoiceEventListener() *VoiceEventListener {
 	metrics := models.NewAuthenticatedDiscordMetricsClient()
 	return &VoiceEventListener{
 		Metrics:    metrics,
 		NotifyChan: make(chan VoiceEvent, 200),
+		seen:       map[string]time.Time{},
 	}
 }
 
@@ -56,7 +72,6 @@ func (l *VoiceEventListener) Start(ctx context.Context) {
 					log.Println("Channel buffer full, skipping event")
 				}
 			}
-			l.LastChecked = time.Now()
 		}
 	}
 }
@@ -71,21 +86,27 @@ func (l *VoiceEventListener) checkNewEvents() ([]VoiceEvent, error) {
 		return nil, fmt.Errorf("DISCORD_GUILD_ID env var is required")
 	}
 
-	// On first run lastCheched is empty, set it to now() to avoid processing old events
-	if l.LastChecked.IsZero() {
-		l.LastChecked = time.Now()
+	// On first run LastChecked is empty, the events of the overlap are only marked as seen to avoid processing old events
+	now := time.Now()
+	lastChecked := l.LastChecked
+	firstRun := lastChecked.IsZero()
+	if firstRun {
+		lastChecked = now
 	}
 
-	query := fmt.Sprintf(`from(bucket:"%s")
-		|> range(start: %s, stop: %s)
-		|> filter(fn: (r) => r._measurement == "voice_events" and r.guild_id == "%s" and(r.event_type == "voice" or r.event_type == "webcam" or r.event_type == "streaming"))
-		|> sort(columns: ["_time"])`,
-		l.Metrics.Bucket,
-		l.LastChecked.Format(time.RFC3339),
-		time.Now().Format(time.RFC3339),
-		discordGuildId)
-
-	result, err := l.Metrics.Client.QueryAPI(l.Metrics.Org).Query(context.Background(), query)
+	// The window overlaps the previous one and the events already seen are skipped
+	start := lastChecked.Add(-pollOverlap)
+	query := flux.From(l.Metrics.Bucket).
+		Range(start, now).
+		Filter(flux.And(
+			flux.Eq("_measurement", models.VoiceEventsMeasurement),
+			flux.Eq(models.GuildIdKey, discordGuildId),
+			flux.Eq("_field", models.StateKey),
+			flux.In(models.EventTypeKey, models.VoiceEvent, models.WebcamEvent, models.StreamEvent),
+		)).
+		Sort(false, "_time")
+
+	result, err := l.Metrics.Client.QueryAPI(l.Metrics.Org).Query(context.Background(), query.String())
 	if err != nil {
 		return nil, fmt.Errorf("query error: %w", err)
 	}
@@ -111,6 +132,12 @@ func (l *VoiceEventListener) checkNewEvents() ([]VoiceEvent, error) {
 			continue
 		}
 
+		key := fmt.Sprintf("%s/%s/%s/%d", userID, channelID, eventType, record.Time().UnixNano())
+		if _, ok := l.seen[key]; ok {
+			continue
+		}
+		l.seen[key] = record.Time()
+
 		event := VoiceEvent{
 			UserID:         userID,
 			Username:       username,
@@ -119,6 +146,7 @@ func (l *VoiceEventListener) checkNewEvents() ([]VoiceEvent, error) {
 			ChannelName:    channelName,
 			EventType:      eventType,
 			State:          state,
+			TelegramUserID: l.accountLinks()[userID].UserID,
 		}
 		events = append(events, event)
 	}
@@ -127,5 +155,32 @@ func (l *VoiceEventListener) checkNewEvents() ([]VoiceEvent, error) {
 		return nil, fmt.Errorf("error iterating results: %w", err)
 	}
 
+	// Events older than the next window can't be read again
+	l.LastChecked = now
+	for key, at := range l.seen {
+		if at.Before(now.Add(-pollOverlap)) {
+			delete(l.seen, key)
+		}
+	}
+	if firstRun {
+		return nil, nil
+	}
 	return events, nil
 }
+
+// accountLinks returns the cached Telegram accounts linked to Discord users, notifications are
+// still sent without mentions when the links can't be fetched
+func (l *VoiceEventListener) accountLinks() map[string]models.TelegramAccount {
+	if time.Since(l.linksCheckedAt) < linksRefreshInterval {
+		return l.links
+	}
+	l.linksCheckedAt = time.Now()
+
+	links, err := l.Metrics.GetAccountLinks()
+	if err != nil {
+		log.Printf("Error fetching account links: %v", err)
+		return l.links
+	}
+	l.links = links
+	return l.links
+}
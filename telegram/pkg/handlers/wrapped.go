@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/cerverox9/discord/pkg/stats"
+)
+
+// wrappedMessageDelay spaces out the messages of a Wrapped so they read like slides
+const wrappedMessageDelay = 2 * time.Second
+
+// WrappedHandler sends the year in review of the server, or of a user, as a sequence of messages.
+// A trailing year such as 2024 picks the year, the current one by default.
+func WrappedHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	s := stats.NewStatsFromEnv()
+	args := strings.Fields(update.Message.Text)[1:]
+	now := time.Now().In(s.Location)
+	year := now.Year()
+	if len(args) > 0 {
+		if value, err := strconv.Atoi(args[len(args)-1]); err == nil && value >= 2000 && value <= now.Year() {
+			year = value
+			args = args[:len(args)-1]
+		}
+	}
+
+	var userID, who string
+	if len(args) > 0 {
+		user, ok := resolveUser(ctx, b, update, strings.Join(args, " "))
+		if !ok {
+			return
+		}
+		userID, who = user.UserID, user.Label()
+	}
+
+	wrapped, err := s.GetWrapped(userID, year)
+	if err != nil {
+		log.Println("error building wrapped:", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Error building the Wrapped",
+		})
+		return
+	}
+	sendSlides(ctx, b, update.Message.Chat.ID, stats.FormatWrapped(wrapped, who, year, s.Location))
+}
+
+// sendSlides sends the messages one after the other, stopping at the first one that fails
+func sendSlides(ctx context.Context, b *bot.Bot, chatID int64, messages []string) error {
+	for i, message := range messages {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wrappedMessageDelay):
+			}
+		}
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   message,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}